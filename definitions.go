@@ -1,10 +1,40 @@
 package tagmap
 
+import "strconv"
+
+// Tag is index of the tag in the registry, tags that reuse index of an unregistered one also carry generation in the high bits
 type Tag int
 type TagName string
 
 const UnknownTag = Tag(-1)
 
+const (
+	tagIndexBits = 24
+	// generationBits is what is left of Tag above the index, but no more than fits into uint32
+	generationBits = 7 + 25*(strconv.IntSize/64)
+
+	// MaxTagIndex is the highest index of a tag, registry holds at most MaxTagIndex+1 tags
+	MaxTagIndex = 1<<tagIndexBits - 1
+	// MaxGeneration is the highest generation of a tag, index that reached it is not reused anymore
+	MaxGeneration = 1<<generationBits - 1
+)
+
+// MakeTag builds tag from index and generation
+func MakeTag(idx int, gen uint32) Tag {
+	return Tag(gen)<<tagIndexBits | Tag(idx)
+}
+
+// Index returns position of the tag in the registry, maps keep values by it
+func (t Tag) Index() int {
+	return int(t & MaxTagIndex)
+}
+
+// Generation tells apart tags that share index: index of an unregistered tag is reused by the next registered one
+// with generation incremented, so maps can tell the old tag from the new one
+func (t Tag) Generation() uint32 {
+	return uint32(t >> tagIndexBits)
+}
+
 type List[V any] []V
 
 func (l *List[V]) ToIList() []interface{} {
@@ -0,0 +1,22 @@
+package tagmap
+
+import "errors"
+
+var (
+	ErrUnknownTag   = errors.New("there is no such tag")
+	ErrDuplicateTag = errors.New("tag is already registered")
+	ErrSealed       = errors.New("registry is sealed")
+	ErrNotSealed    = errors.New("registry is not sealed")
+	// ErrStaleTag is returned for writes via tags that were unregistered
+	ErrStaleTag = errors.New("tag was unregistered")
+	// ErrConflictingTag is returned when imported tag is registered differently
+	ErrConflictingTag = errors.New("tag conflicts with registered one")
+	// ErrBadManifest is returned for registry manifests that can not be decoded or imported
+	ErrBadManifest = errors.New("bad manifest")
+	// ErrTooManyTags is returned when registry has no index left for a new tag, see MaxTagIndex
+	ErrTooManyTags = errors.New("too many tags")
+	// ErrComputePanicked is returned to callers that waited for a compute function that panicked
+	ErrComputePanicked = errors.New("compute function panicked")
+	// ErrBadDefault is returned for tag defaults that can not be converted to the map value type
+	ErrBadDefault = errors.New("default does not fit value type")
+)
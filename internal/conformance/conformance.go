@@ -0,0 +1,150 @@
+// Package conformance holds test suites shared by all map implementations
+package conformance
+
+import (
+	"testing"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetOrSet checks that GetByTagOrSet behaves like sync.Map.LoadOrStore:
+// value is stored only if tag is not set, zero value counts as set
+func TestGetOrSet(t *testing.T, newMap func(r *registry.TagRegistry) tagmap.Map[string]) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := newMap(r)
+
+	val, loaded := m.GetByTagOrSet(tag1, "val1")
+	assert.False(t, loaded)
+	assert.Equal(t, "val1", val)
+	val, loaded = m.GetByTagOrSet(tag1, "other")
+	assert.True(t, loaded)
+	assert.Equal(t, "val1", val)
+	val, loaded = m.GetByTagOrSet(tag1, "")
+	assert.True(t, loaded)
+	assert.Equal(t, "val1", val)
+	assert.Equal(t, "val1", m.GetByTag(tag1))
+
+	val, loaded = m.GetByNameOrSet("tag2", "")
+	assert.False(t, loaded)
+	assert.Equal(t, "", val)
+	val, loaded = m.GetByTagOrSet(tag2, "val2")
+	assert.True(t, loaded)
+	assert.Equal(t, "", val)
+	val, loaded, err := m.LoadOrStoreByName("tag2", "val2")
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, "", val)
+	assert.Equal(t, map[tagmap.Tag]string{tag1: "val1", tag2: ""}, m.ValuesByTag())
+
+	m.DeleteByTag(tag1)
+	val, loaded = m.GetByTagOrSet(tag1, "val3")
+	assert.False(t, loaded)
+	assert.Equal(t, "val3", val)
+
+	assert.Equal(t, "val3", m.GetByTagAndDelete(tag1))
+	val, loaded = m.GetByNameOrSet("tag1", "val4")
+	assert.False(t, loaded)
+	assert.Equal(t, "val4", val)
+
+	m.SetByTag(tag2, "val5")
+	val, loaded = m.GetByNameOrSet("tag2", "val6")
+	assert.True(t, loaded)
+	assert.Equal(t, "val5", val)
+	assert.Equal(t, "val5", m.GetByName("tag2"))
+
+	tag3 := r.RegisterTag("tag3")
+	val, loaded = m.GetByTagOrSet(tag3, "val7")
+	assert.False(t, loaded)
+	assert.Equal(t, "val7", val)
+}
+
+// TestLoad checks that values are reported only for set tags
+func TestLoad(t *testing.T, newMap func(r *registry.TagRegistry) tagmap.Map[string]) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := newMap(r)
+
+	val, ok := m.Load(tag1)
+	assert.False(t, ok)
+	assert.Equal(t, "", val)
+	assert.False(t, m.Has(tag1))
+	assert.Empty(t, m.ValuesByTag())
+	assert.Empty(t, m.ValuesByName())
+
+	m.SetByTag(tag1, "")
+	m.SetByName("tag2", "val2")
+	val, ok = m.Load(tag1)
+	assert.True(t, ok)
+	assert.Equal(t, "", val)
+	assert.True(t, m.Has(tag2))
+	assert.Equal(t, map[tagmap.Tag]string{tag1: "", tag2: "val2"}, m.ValuesByTag())
+	assert.Equal(t, map[tagmap.TagName]string{"tag1": "", "tag2": "val2"}, m.ValuesByName())
+	assert.Equal(t, tagmap.List[string]{"val2", ""}, m.GetValuesByTag(tag2, tag1))
+	assert.Equal(t, tagmap.List[string]{"val2", ""}, m.GetValuesByName("tag2", "tag1"))
+
+	m.DeleteByName("tag1")
+	assert.False(t, m.Has(tag1))
+	assert.Equal(t, "val2", m.GetByNameAndDelete("tag2"))
+	assert.False(t, m.Has(tag2))
+	assert.Empty(t, m.ValuesByTag())
+
+	assert.True(t, m.IsTagName("tag1"))
+	assert.False(t, m.IsTagName("tag3"))
+	assert.Equal(t, tag2, m.TagByName("tag2"))
+	_, err := m.LoadByName("tag3")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.StoreByName("tag3", ""), tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.RemoveByName("tag3"), tagmap.ErrUnknownTag)
+	_, err = m.LoadAndDeleteByName("tag3")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.Panics(t, func() { m.GetByName("tag3") })
+	assert.Panics(t, func() { m.SetByName("tag3", "") })
+}
+
+// TestUnregister checks that value of an unregistered tag is not visible via the tag that reused its index,
+// while writes via the unregistered tag fail and deletes do nothing
+func TestUnregister(t *testing.T, newMap func(r *registry.TagRegistry) tagmap.Map[string]) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := newMap(r)
+	m.SetByTag(tag1, "val1")
+	m.SetByTag(tag2, "val2")
+
+	r.Unregister("tag1")
+	assert.Equal(t, map[tagmap.TagName]string{"tag2": "val2"}, m.ValuesByName())
+	assert.False(t, m.Has(tag1))
+	tag3 := r.RegisterTag("tag3")
+	assert.Equal(t, tag1.Index(), tag3.Index())
+	assert.False(t, m.Has(tag3))
+	// tag3 has not written its value yet, so the slot still holds value of tag1
+	assert.Panics(t, func() { m.SetByTag(tag1, "stale") })
+	assert.Equal(t, "", m.GetByTag(tag1))
+	assert.False(t, m.Has(tag3))
+	assert.Equal(t, "", m.GetByName("tag3"))
+	val, loaded := m.GetByTagOrSet(tag3, "val3")
+	assert.False(t, loaded)
+	assert.Equal(t, "val3", val)
+
+	_, ok := m.Load(tag1)
+	assert.False(t, ok)
+	assert.Equal(t, "", m.GetByTag(tag1))
+	assert.Panics(t, func() { m.SetByTag(tag1, "") })
+	m.DeleteByTag(tag1)
+	assert.Equal(t, "", m.GetByTagAndDelete(tag1))
+	assert.Equal(t, map[tagmap.Tag]string{tag2: "val2", tag3: "val3"}, m.ValuesByTag())
+
+	// tags unregistered before sealing stay stale for maps built from the sealed registry
+	r.Unregister("tag2")
+	r.Seal()
+	sealed := newMap(r)
+	assert.Panics(t, func() { sealed.SetByTag(tag2, "stale") })
+	assert.False(t, sealed.Has(tag2))
+	sealed.SetByTag(tag3, "val3")
+	assert.Equal(t, map[tagmap.Tag]string{tag3: "val3"}, sealed.ValuesByTag())
+}
@@ -0,0 +1,38 @@
+package tagmap
+
+// ReadOnlyMap is the read part of Map
+type ReadOnlyMap[V any] interface {
+	IsTagName(name TagName) bool
+	TagByName(name TagName) Tag
+
+	GetByName(name TagName) V
+	GetByTag(tag Tag) V
+	Load(tag Tag) (V, bool)
+	Has(tag Tag) bool
+	LoadByName(name TagName) (V, error)
+
+	ValuesByTag() map[Tag]V
+	ValuesByName() map[TagName]V
+	GetValuesByName(names ...TagName) List[V]
+	GetValuesByTag(tags ...Tag) List[V]
+}
+
+// Map is implemented by both tags.TagMap and stags.SafeTagMap,
+// so code can switch between single-threaded and thread-safe maps
+type Map[V any] interface {
+	ReadOnlyMap[V]
+
+	SetByName(name TagName, val V)
+	SetByTag(tag Tag, val V)
+	GetByNameOrSet(name TagName, val V) (V, bool)
+	GetByTagOrSet(tag Tag, val V) (V, bool)
+	GetByNameAndDelete(name TagName) V
+	GetByTagAndDelete(tag Tag) V
+	DeleteByName(name TagName)
+	DeleteByTag(tag Tag)
+
+	StoreByName(name TagName, val V) error
+	LoadOrStoreByName(name TagName, val V) (V, bool, error)
+	LoadAndDeleteByName(name TagName) (V, error)
+	RemoveByName(name TagName) error
+}
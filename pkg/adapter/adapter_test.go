@@ -0,0 +1,118 @@
+package adapter_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/internal/conformance"
+	"github.com/go-auxiliaries/tagmap/pkg/adapter"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSyncMap(r *registry.TagRegistry) tagmap.Map[string] {
+	return adapter.FromSyncMap[string](r, &sync.Map{})
+}
+
+func newPlainMap(r *registry.TagRegistry) tagmap.Map[string] {
+	return adapter.FromMap(r, map[tagmap.TagName]string{})
+}
+
+func TestGetOrSet(t *testing.T) {
+	conformance.TestGetOrSet(t, newSyncMap)
+	conformance.TestGetOrSet(t, newPlainMap)
+}
+
+func TestLoad(t *testing.T) {
+	conformance.TestLoad(t, newSyncMap)
+	conformance.TestLoad(t, newPlainMap)
+}
+
+func TestUnregister(t *testing.T) {
+	conformance.TestUnregister(t, newSyncMap)
+	conformance.TestUnregister(t, newPlainMap)
+}
+
+func TestUnknownTag(t *testing.T) {
+	r := registry.New()
+	for _, m := range []tagmap.Map[int]{adapter.FromSyncMap[int](r, &sync.Map{}), adapter.FromMap(r, map[tagmap.TagName]int{})} {
+		assert.Panics(t, func() { m.SetByTag(tagmap.UnknownTag, 5) })
+		assert.Panics(t, func() { m.GetByTagOrSet(tagmap.Tag(3), 5) })
+		assert.False(t, m.Has(tagmap.Tag(3)))
+		m.DeleteByTag(tagmap.UnknownTag)
+		assert.Empty(t, m.ValuesByTag())
+	}
+	assert.Equal(t, tagmap.TagName(""), r.GetName(tagmap.Tag(3)))
+}
+
+func TestNilInterface(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("a")
+	m := adapter.FromSyncMap[error](r, &sync.Map{})
+	val, loaded := m.GetByNameOrSet("a", nil)
+	assert.Nil(t, val)
+	assert.False(t, loaded)
+	val, loaded = m.GetByTagOrSet(tag, nil)
+	assert.Nil(t, val)
+	assert.True(t, loaded)
+	_, loaded, err := m.LoadOrStoreByName("a", nil)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	val, ok := m.Load(tag)
+	assert.Nil(t, val)
+	assert.True(t, ok)
+	assert.Equal(t, map[tagmap.TagName]error{"a": nil}, m.ValuesByName())
+	assert.Equal(t, map[tagmap.Tag]error{tag: nil}, m.ValuesByTag())
+	assert.Nil(t, m.GetByTagAndDelete(tag))
+	assert.False(t, m.Has(tag))
+}
+
+func TestWrapped(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+
+	syncMap := &sync.Map{}
+	syncMap.Store(tagmap.TagName("tag"), 1)
+	syncMap.Store(tagmap.TagName("unknown"), 2)
+	wrappedSync := adapter.FromSyncMap[int](r, syncMap)
+	assert.Equal(t, 1, wrappedSync.GetByTag(tag))
+	assert.Equal(t, map[tagmap.TagName]int{"tag": 1}, wrappedSync.ValuesByName())
+	wrappedSync.SetByTag(tag, 3)
+	val, _ := syncMap.Load(tagmap.TagName("tag"))
+	assert.Equal(t, 3, val)
+
+	// code that keys sync.Map by plain strings keeps working with it
+	stringMap := &sync.Map{}
+	stringMap.Store("tag", 1)
+	stringMap.Store("unknown", 2)
+	stringMap.Store(42, 3)
+	wrappedString := adapter.FromSyncMap[int](r, stringMap)
+	assert.Equal(t, 1, wrappedString.GetByName("tag"))
+	assert.Equal(t, map[tagmap.Tag]int{tag: 1}, wrappedString.ValuesByTag())
+	assert.Equal(t, map[tagmap.TagName]int{"tag": 1}, wrappedString.ValuesByName())
+	wrappedString.SetByTag(tag, 3)
+	val, _ = stringMap.Load("tag")
+	assert.Equal(t, 3, val)
+	_, ok := stringMap.Load(tagmap.TagName("tag"))
+	assert.False(t, ok, "value is updated under the key that is stored")
+	other := r.RegisterTag("other")
+	actual, loaded := wrappedString.GetByTagOrSet(other, 4)
+	assert.False(t, loaded)
+	assert.Equal(t, 4, actual)
+	val, _ = stringMap.Load("other")
+	assert.Equal(t, 4, val, "new values are stored under string keys as well")
+	assert.Equal(t, 3, wrappedString.GetByTagAndDelete(tag))
+	wrappedString.DeleteByName("other")
+	assert.Empty(t, wrappedString.ValuesByName())
+	// tag names are found in a map keyed by strings too
+	stringMap.Store(tagmap.TagName("tag"), 5)
+	assert.Equal(t, 5, wrappedString.GetByTag(tag))
+
+	plainMap := map[tagmap.TagName]int{"tag": 1, "unknown": 2}
+	wrappedPlain := adapter.FromMap(r, plainMap)
+	assert.Equal(t, 1, wrappedPlain.GetByTag(tag))
+	assert.Equal(t, map[tagmap.Tag]int{tag: 1}, wrappedPlain.ValuesByTag())
+	wrappedPlain.SetByTag(tag, 3)
+	assert.Equal(t, 3, plainMap["tag"])
+}
@@ -0,0 +1,227 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// PlainMap exposes map keyed by tag names as tagmap.Map, it is not safe for concurrent use,
+// it is meant to ease migration of code that uses plain maps to tags.TagMap
+type PlainMap[V any] struct {
+	values   map[tagmap.TagName]V
+	registry *registry.TagRegistry
+}
+
+var _ tagmap.Map[int] = (*PlainMap[int])(nil)
+
+// FromMap wraps m, changes made via PlainMap are visible in m and vice versa
+func FromMap[V any](r *registry.TagRegistry, m map[tagmap.TagName]V) *PlainMap[V] {
+	return &PlainMap[V]{
+		values:   m,
+		registry: r,
+	}
+}
+
+func (m *PlainMap[V]) IsTagName(name tagmap.TagName) bool {
+	return m.registry.GetTag(name) != tagmap.UnknownTag
+}
+
+func (m *PlainMap[V]) TagByName(name tagmap.TagName) tagmap.Tag {
+	return m.registry.GetTag(name)
+}
+
+// nameOf resolves tag name for reading, it reports false if tag is unknown or was unregistered
+func nameOf(r *registry.TagRegistry, tag tagmap.Tag) (tagmap.TagName, bool) {
+	if !r.Has(tag) {
+		return "", false
+	}
+	return r.GetName(tag), true
+}
+
+// mustNameOf resolves tag name for writing
+// !! It will fail if tag is unknown or was unregistered !!
+func mustNameOf(r *registry.TagRegistry, tag tagmap.Tag) tagmap.TagName {
+	name, ok := nameOf(r, tag)
+	if ok {
+		return name
+	}
+	if idx := r.IndexOf(tag); idx >= 0 && idx < r.GetLen() {
+		panic(fmt.Errorf("%w: %d", tagmap.ErrStaleTag, tag))
+	}
+	panic(fmt.Errorf("%w: %d", tagmap.ErrUnknownTag, tag))
+}
+
+func (m *PlainMap[V]) getName(name tagmap.TagName) tagmap.TagName {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// GetByName gets tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName or use LoadByName
+func (m *PlainMap[V]) GetByName(name tagmap.TagName) V {
+	return m.values[m.getName(name)]
+}
+
+func (m *PlainMap[V]) GetByTag(tag tagmap.Tag) V {
+	val, _ := m.Load(tag)
+	return val
+}
+
+func (m *PlainMap[V]) Load(tag tagmap.Tag) (V, bool) {
+	name, ok := nameOf(m.registry, tag)
+	if !ok {
+		return *new(V), false
+	}
+	val, ok := m.values[name]
+	return val, ok
+}
+
+func (m *PlainMap[V]) Has(tag tagmap.Tag) bool {
+	_, ok := m.Load(tag)
+	return ok
+}
+
+func (m *PlainMap[V]) LoadByName(name tagmap.TagName) (V, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), err
+	}
+	return m.values[name], nil
+}
+
+// SetByName sets tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName or use StoreByName
+func (m *PlainMap[V]) SetByName(name tagmap.TagName, val V) {
+	m.values[m.getName(name)] = val
+}
+
+func (m *PlainMap[V]) SetByTag(tag tagmap.Tag, val V) {
+	m.values[mustNameOf(m.registry, tag)] = val
+}
+
+func (m *PlainMap[V]) StoreByName(name tagmap.TagName, val V) error {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return err
+	}
+	m.values[name] = val
+	return nil
+}
+
+// GetByNameOrSet does the same as GetByTagOrSet
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *PlainMap[V]) GetByNameOrSet(name tagmap.TagName, val V) (V, bool) {
+	return m.loadOrStore(m.getName(name), val)
+}
+
+func (m *PlainMap[V]) GetByTagOrSet(tag tagmap.Tag, val V) (V, bool) {
+	return m.loadOrStore(mustNameOf(m.registry, tag), val)
+}
+
+func (m *PlainMap[V]) LoadOrStoreByName(name tagmap.TagName, val V) (V, bool, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), false, err
+	}
+	actual, loaded := m.loadOrStore(name, val)
+	return actual, loaded, nil
+}
+
+func (m *PlainMap[V]) loadOrStore(name tagmap.TagName, val V) (V, bool) {
+	if actual, ok := m.values[name]; ok {
+		return actual, true
+	}
+	m.values[name] = val
+	return val, false
+}
+
+// GetByNameAndDelete does the same as GetByTagAndDelete
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *PlainMap[V]) GetByNameAndDelete(name tagmap.TagName) V {
+	return m.loadAndDelete(m.getName(name))
+}
+
+func (m *PlainMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
+	name, ok := nameOf(m.registry, tag)
+	if !ok {
+		return *new(V)
+	}
+	return m.loadAndDelete(name)
+}
+
+func (m *PlainMap[V]) LoadAndDeleteByName(name tagmap.TagName) (V, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), err
+	}
+	return m.loadAndDelete(name), nil
+}
+
+func (m *PlainMap[V]) loadAndDelete(name tagmap.TagName) V {
+	val := m.values[name]
+	delete(m.values, name)
+	return val
+}
+
+// DeleteByName deletes tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *PlainMap[V]) DeleteByName(name tagmap.TagName) {
+	delete(m.values, m.getName(name))
+}
+
+func (m *PlainMap[V]) DeleteByTag(tag tagmap.Tag) {
+	if name, ok := nameOf(m.registry, tag); ok {
+		delete(m.values, name)
+	}
+}
+
+func (m *PlainMap[V]) RemoveByName(name tagmap.TagName) error {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return err
+	}
+	delete(m.values, name)
+	return nil
+}
+
+// ValuesByTag returns all values, keys that are not registered tags are skipped
+func (m *PlainMap[V]) ValuesByTag() map[tagmap.Tag]V {
+	out := make(map[tagmap.Tag]V, len(m.values))
+	for name, value := range m.values {
+		if tag := m.registry.GetTag(name); tag != tagmap.UnknownTag {
+			out[tag] = value
+		}
+	}
+	return out
+}
+
+// ValuesByName returns all values, keys that are not registered tags are skipped
+func (m *PlainMap[V]) ValuesByName() map[tagmap.TagName]V {
+	out := make(map[tagmap.TagName]V, len(m.values))
+	for name, value := range m.values {
+		if m.IsTagName(name) {
+			out[name] = value
+		}
+	}
+	return out
+}
+
+func (m *PlainMap[V]) GetValuesByName(names ...tagmap.TagName) tagmap.List[V] {
+	out := make(tagmap.List[V], len(names))
+	for idx, name := range names {
+		out[idx] = m.GetByName(name)
+	}
+	return out
+}
+
+func (m *PlainMap[V]) GetValuesByTag(tags ...tagmap.Tag) tagmap.List[V] {
+	out := make(tagmap.List[V], len(tags))
+	for idx, tag := range tags {
+		out[idx] = m.GetByTag(tag)
+	}
+	return out
+}
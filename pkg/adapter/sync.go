@@ -0,0 +1,275 @@
+package adapter
+
+import (
+	"sync"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// SyncMap exposes sync.Map keyed by tag names as tagmap.Map,
+// it is meant to ease migration of code that uses sync.Map to stags.SafeTagMap.
+// Keys can be either tagmap.TagName or plain string, values are found under either of them,
+// updates keep the key that is already stored, other keys are skipped.
+type SyncMap[V any] struct {
+	values   *sync.Map
+	registry *registry.TagRegistry
+	// stringKeys is set if new values are stored under string keys
+	stringKeys bool
+}
+
+var _ tagmap.Map[int] = (*SyncMap[int])(nil)
+
+// FromSyncMap wraps m, keys of m are expected to be tag names of type tagmap.TagName or string and values of type V.
+// New values are stored under keys of the same type as keys that are already in m, tagmap.TagName if m is empty.
+func FromSyncMap[V any](r *registry.TagRegistry, m *sync.Map) *SyncMap[V] {
+	out := &SyncMap[V]{
+		values:   m,
+		registry: r,
+	}
+	m.Range(func(key, _ any) bool {
+		_, isName := nameOfKey(key)
+		_, out.stringKeys = key.(string)
+		return !isName
+	})
+	return out
+}
+
+// nameOfKey converts key of the sync.Map to tag name, it reports false for keys of other types
+func nameOfKey(key any) (tagmap.TagName, bool) {
+	switch key := key.(type) {
+	case tagmap.TagName:
+		return key, true
+	case string:
+		return tagmap.TagName(key), true
+	}
+	return "", false
+}
+
+// keys returns both keys name can be stored under, the one new values are stored under goes first
+func (m *SyncMap[V]) keys(name tagmap.TagName) [2]any {
+	if m.stringKeys {
+		return [2]any{string(name), name}
+	}
+	return [2]any{name, string(name)}
+}
+
+// keyOf returns key name is stored under, or the key to store it under if it is not stored yet
+func (m *SyncMap[V]) keyOf(name tagmap.TagName) any {
+	keys := m.keys(name)
+	if _, ok := m.values.Load(keys[1]); ok {
+		return keys[1]
+	}
+	return keys[0]
+}
+
+func (m *SyncMap[V]) IsTagName(name tagmap.TagName) bool {
+	return m.registry.GetTag(name) != tagmap.UnknownTag
+}
+
+func (m *SyncMap[V]) TagByName(name tagmap.TagName) tagmap.Tag {
+	return m.registry.GetTag(name)
+}
+
+func (m *SyncMap[V]) getName(name tagmap.TagName) tagmap.TagName {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// valueOf converts stored value to V, nil interface values are stored as nil, which plain type assertion rejects
+func valueOf[V any](val any) V {
+	out, _ := val.(V)
+	return out
+}
+
+func (m *SyncMap[V]) load(name tagmap.TagName) (V, bool) {
+	for _, key := range m.keys(name) {
+		if val, ok := m.values.Load(key); ok {
+			return valueOf[V](val), true
+		}
+	}
+	return *new(V), false
+}
+
+func (m *SyncMap[V]) store(name tagmap.TagName, val V) {
+	m.values.Store(m.keyOf(name), val)
+}
+
+func (m *SyncMap[V]) loadOrStore(name tagmap.TagName, val V) (V, bool) {
+	actual, loaded := m.values.LoadOrStore(m.keyOf(name), val)
+	return valueOf[V](actual), loaded
+}
+
+func (m *SyncMap[V]) delete(name tagmap.TagName) {
+	for _, key := range m.keys(name) {
+		m.values.Delete(key)
+	}
+}
+
+// GetByName gets tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName or use LoadByName
+func (m *SyncMap[V]) GetByName(name tagmap.TagName) V {
+	val, _ := m.load(m.getName(name))
+	return val
+}
+
+func (m *SyncMap[V]) GetByTag(tag tagmap.Tag) V {
+	val, _ := m.Load(tag)
+	return val
+}
+
+func (m *SyncMap[V]) Load(tag tagmap.Tag) (V, bool) {
+	name, ok := nameOf(m.registry, tag)
+	if !ok {
+		return *new(V), false
+	}
+	return m.load(name)
+}
+
+func (m *SyncMap[V]) Has(tag tagmap.Tag) bool {
+	_, ok := m.Load(tag)
+	return ok
+}
+
+func (m *SyncMap[V]) LoadByName(name tagmap.TagName) (V, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), err
+	}
+	val, _ := m.load(name)
+	return val, nil
+}
+
+// SetByName sets tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName or use StoreByName
+func (m *SyncMap[V]) SetByName(name tagmap.TagName, val V) {
+	m.store(m.getName(name), val)
+}
+
+func (m *SyncMap[V]) SetByTag(tag tagmap.Tag, val V) {
+	m.store(mustNameOf(m.registry, tag), val)
+}
+
+func (m *SyncMap[V]) StoreByName(name tagmap.TagName, val V) error {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return err
+	}
+	m.store(name, val)
+	return nil
+}
+
+// GetByNameOrSet does the same as GetByTagOrSet
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SyncMap[V]) GetByNameOrSet(name tagmap.TagName, val V) (V, bool) {
+	return m.loadOrStore(m.getName(name), val)
+}
+
+func (m *SyncMap[V]) GetByTagOrSet(tag tagmap.Tag, val V) (V, bool) {
+	return m.loadOrStore(mustNameOf(m.registry, tag), val)
+}
+
+func (m *SyncMap[V]) LoadOrStoreByName(name tagmap.TagName, val V) (V, bool, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), false, err
+	}
+	actual, loaded := m.loadOrStore(name, val)
+	return actual, loaded, nil
+}
+
+// GetByNameAndDelete does the same as GetByTagAndDelete
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SyncMap[V]) GetByNameAndDelete(name tagmap.TagName) V {
+	return m.loadAndDelete(m.getName(name))
+}
+
+func (m *SyncMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
+	name, ok := nameOf(m.registry, tag)
+	if !ok {
+		return *new(V)
+	}
+	return m.loadAndDelete(name)
+}
+
+func (m *SyncMap[V]) LoadAndDeleteByName(name tagmap.TagName) (V, error) {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return *new(V), err
+	}
+	return m.loadAndDelete(name), nil
+}
+
+func (m *SyncMap[V]) loadAndDelete(name tagmap.TagName) V {
+	for _, key := range m.keys(name) {
+		if val, ok := m.values.LoadAndDelete(key); ok {
+			return valueOf[V](val)
+		}
+	}
+	return *new(V)
+}
+
+// DeleteByName deletes tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SyncMap[V]) DeleteByName(name tagmap.TagName) {
+	m.delete(m.getName(name))
+}
+
+func (m *SyncMap[V]) DeleteByTag(tag tagmap.Tag) {
+	if name, ok := nameOf(m.registry, tag); ok {
+		m.delete(name)
+	}
+}
+
+func (m *SyncMap[V]) RemoveByName(name tagmap.TagName) error {
+	if _, err := m.registry.LookupTag(name); err != nil {
+		return err
+	}
+	m.delete(name)
+	return nil
+}
+
+// ValuesByTag returns all values, keys that are not registered tags are skipped
+func (m *SyncMap[V]) ValuesByTag() map[tagmap.Tag]V {
+	out := make(map[tagmap.Tag]V)
+	m.values.Range(func(key, value any) bool {
+		if name, ok := nameOfKey(key); ok {
+			if tag := m.registry.GetTag(name); tag != tagmap.UnknownTag {
+				out[tag] = valueOf[V](value)
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// ValuesByName returns all values, keys that are not registered tags are skipped
+func (m *SyncMap[V]) ValuesByName() map[tagmap.TagName]V {
+	out := make(map[tagmap.TagName]V)
+	m.values.Range(func(key, value any) bool {
+		if name, ok := nameOfKey(key); ok && m.IsTagName(name) {
+			out[name] = valueOf[V](value)
+		}
+		return true
+	})
+	return out
+}
+
+func (m *SyncMap[V]) GetValuesByName(names ...tagmap.TagName) tagmap.List[V] {
+	out := make(tagmap.List[V], len(names))
+	for idx, name := range names {
+		out[idx] = m.GetByName(name)
+	}
+	return out
+}
+
+func (m *SyncMap[V]) GetValuesByTag(tags ...tagmap.Tag) tagmap.List[V] {
+	out := make(tagmap.List[V], len(tags))
+	for idx, tag := range tags {
+		out[idx] = m.GetByTag(tag)
+	}
+	return out
+}
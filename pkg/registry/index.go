@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"hash/maphash"
+	"math/bits"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+const (
+	trieBits = 5
+	trieMask = 1<<trieBits - 1
+)
+
+var trieSeed = maphash.MakeSeed()
+
+// nameIndex maps names to tags, it is immutable: set and delete return a new index sharing most of the old one.
+// Names live in a plain map for fast lookups, names added since the map was built go to a persistent hash trie,
+// that copies only the path to the changed name, and are moved to a new map once the trie grows by a fraction of it.
+// So registering a tag copies O(log n) entries amortized instead of the whole index, while the old index stays readable.
+type nameIndex struct {
+	base map[tagmap.TagName]tagmap.Tag
+	// recent holds names missing from base, n of them
+	recent *trieNode
+	n      int
+}
+
+// trieNode holds entries for set bits of bitmap in bit order, nodes below all hash bits hold colliding names in a list
+type trieNode struct {
+	bitmap  uint32
+	entries []trieEntry
+}
+
+// trieEntry holds either a child node or a name
+type trieEntry struct {
+	child *trieNode
+	hash  uint64
+	name  tagmap.TagName
+	tag   tagmap.Tag
+}
+
+func hashName(name tagmap.TagName) uint64 {
+	return maphash.String(trieSeed, string(name))
+}
+
+func (ix nameIndex) len() int {
+	return len(ix.base) + ix.n
+}
+
+func (ix nameIndex) get(name tagmap.TagName) (tagmap.Tag, bool) {
+	if tag, ok := ix.base[name]; ok || ix.recent == nil {
+		return tag, ok
+	}
+	h := hashName(name)
+	n := ix.recent
+	for shift := uint(0); n != nil; shift += trieBits {
+		if shift >= 64 {
+			for _, e := range n.entries {
+				if e.name == name {
+					return e.tag, true
+				}
+			}
+			break
+		}
+		bit := uint32(1) << (h >> shift & trieMask)
+		if n.bitmap&bit == 0 {
+			break
+		}
+		e := &n.entries[bits.OnesCount32(n.bitmap&(bit-1))]
+		if e.child == nil {
+			if e.name == name {
+				return e.tag, true
+			}
+			break
+		}
+		n = e.child
+	}
+	return tagmap.UnknownTag, false
+}
+
+// set returns index with name mapped to tag
+func (ix nameIndex) set(name tagmap.TagName, tag tagmap.Tag) nameIndex {
+	if _, ok := ix.base[name]; ok {
+		next := ix.rebuild()
+		next.base[name] = tag
+		return next
+	}
+	recent, added := ix.recent.with(0, trieEntry{hash: hashName(name), name: name, tag: tag})
+	next := nameIndex{base: ix.base, recent: recent, n: ix.n}
+	if added {
+		next.n++
+	}
+	if next.n > len(next.base)/8+16 {
+		return next.rebuild()
+	}
+	return next
+}
+
+// delete returns index without name
+func (ix nameIndex) delete(name tagmap.TagName) nameIndex {
+	if _, ok := ix.base[name]; ok {
+		next := ix.rebuild()
+		delete(next.base, name)
+		return next
+	}
+	recent, removed := ix.recent.without(0, hashName(name), name)
+	next := nameIndex{base: ix.base, recent: recent, n: ix.n}
+	if removed {
+		next.n--
+	}
+	return next
+}
+
+// rebuild moves all names to a new base, it can be modified until the index is published
+func (ix nameIndex) rebuild() nameIndex {
+	base := make(map[tagmap.TagName]tagmap.Tag, ix.len()+1)
+	for k, v := range ix.base {
+		base[k] = v
+	}
+	ix.recent.each(func(e *trieEntry) {
+		base[e.name] = e.tag
+	})
+	return nameIndex{base: base}
+}
+
+func (n *trieNode) each(fn func(e *trieEntry)) {
+	if n == nil {
+		return
+	}
+	for i := range n.entries {
+		if e := &n.entries[i]; e.child != nil {
+			e.child.each(fn)
+		} else {
+			fn(e)
+		}
+	}
+}
+
+// with returns copy of the node with e added or replaced, n may be nil
+func (n *trieNode) with(shift uint, e trieEntry) (*trieNode, bool) {
+	var cur trieNode
+	if n != nil {
+		cur = *n
+	}
+	if shift >= 64 {
+		for i := range cur.entries {
+			if cur.entries[i].name == e.name {
+				next := &trieNode{entries: append([]trieEntry(nil), cur.entries...)}
+				next.entries[i] = e
+				return next, false
+			}
+		}
+		return &trieNode{entries: append(cur.entries[:len(cur.entries):len(cur.entries)], e)}, true
+	}
+	bit := uint32(1) << (e.hash >> shift & trieMask)
+	pos := bits.OnesCount32(cur.bitmap & (bit - 1))
+	if cur.bitmap&bit == 0 {
+		entries := make([]trieEntry, len(cur.entries)+1)
+		copy(entries, cur.entries[:pos])
+		entries[pos] = e
+		copy(entries[pos+1:], cur.entries[pos:])
+		return &trieNode{bitmap: cur.bitmap | bit, entries: entries}, true
+	}
+	next := &trieNode{bitmap: cur.bitmap, entries: append([]trieEntry(nil), cur.entries...)}
+	old := cur.entries[pos]
+	added := true
+	switch {
+	case old.child != nil:
+		next.entries[pos].child, added = old.child.with(shift+trieBits, e)
+	case old.name == e.name:
+		next.entries[pos], added = e, false
+	default:
+		child, _ := (*trieNode)(nil).with(shift+trieBits, old)
+		child, _ = child.with(shift+trieBits, e)
+		next.entries[pos] = trieEntry{child: child}
+	}
+	return next, added
+}
+
+// without returns copy of the node without name, it returns nil instead of an empty node
+func (n *trieNode) without(shift uint, h uint64, name tagmap.TagName) (*trieNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	pos := -1
+	if shift >= 64 {
+		for i := range n.entries {
+			if n.entries[i].name == name {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return n, false
+		}
+		return n.remove(pos, 0), true
+	}
+	bit := uint32(1) << (h >> shift & trieMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	pos = bits.OnesCount32(n.bitmap & (bit - 1))
+	e := n.entries[pos]
+	if e.child == nil {
+		if e.name != name {
+			return n, false
+		}
+		return n.remove(pos, bit), true
+	}
+	child, removed := e.child.without(shift+trieBits, h, name)
+	if !removed {
+		return n, false
+	}
+	if child == nil {
+		return n.remove(pos, bit), true
+	}
+	next := &trieNode{bitmap: n.bitmap, entries: append([]trieEntry(nil), n.entries...)}
+	next.entries[pos].child = child
+	return next, true
+}
+
+// remove returns copy of the node without entry at pos, bit is the bit of the entry in bitmap
+func (n *trieNode) remove(pos int, bit uint32) *trieNode {
+	if len(n.entries) == 1 {
+		return nil
+	}
+	entries := make([]trieEntry, 0, len(n.entries)-1)
+	entries = append(entries, n.entries[:pos]...)
+	entries = append(entries, n.entries[pos+1:]...)
+	return &trieNode{bitmap: n.bitmap &^ bit, entries: entries}
+}
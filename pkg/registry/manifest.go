@@ -0,0 +1,202 @@
+package registry
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// Manifest pins tag names to tags, so that processes that import it agree on tags regardless of registration order.
+// It is encoded to JSON as is, MarshalBinary gives a compact form.
+type Manifest struct {
+	Tags []ManifestTag `json:"tags"`
+}
+
+// ManifestTag is a single entry of Manifest
+type ManifestTag struct {
+	Name       tagmap.TagName `json:"name"`
+	Index      int            `json:"index"`
+	Generation uint32         `json:"generation,omitempty"`
+}
+
+// Tag returns tag the entry pins its name to
+func (t ManifestTag) Tag() tagmap.Tag {
+	return tagmap.MakeTag(t.Index, t.Generation)
+}
+
+// binary form starts with manifestMagic followed by a version byte
+const (
+	manifestMagic   = "TMAN"
+	manifestVersion = 1
+)
+
+// Manifest returns all registered tags ordered by index, manifest of a namespace holds only tags of the namespace
+func (r *TagRegistry) Manifest() Manifest {
+	root := r
+	if r.ns != nil {
+		root = r.ns.root
+	}
+	s := root.load()
+	m := Manifest{Tags: make([]ManifestTag, 0, s.byName.len())}
+	for idx, name := range s.tags {
+		tag := tagmap.MakeTag(idx, s.gen(idx))
+		if !s.has(tag) || r.ns != nil && !r.ns.contains(name) {
+			continue
+		}
+		m.Tags = append(m.Tags, ManifestTag{Name: name, Index: idx, Generation: tag.Generation()})
+	}
+	return m
+}
+
+// Import registers all tags of the manifest exactly as they are pinned there,
+// tags that are already registered the same way are left as is. Indices below the highest pinned one
+// that are not pinned stay free, they are taken by tags registered later.
+// It returns tagmap.ErrConflictingTag if any name is registered as another tag or any tag is taken by another name,
+// tagmap.ErrSealed if registry is sealed and the manifest has new tags, nothing is registered then.
+// Namespace imports only tags of the namespace, names in the manifest are full names.
+func (r *TagRegistry) Import(m Manifest) error {
+	if r.ns != nil {
+		for _, t := range m.Tags {
+			if !r.ns.contains(t.Name) {
+				return fmt.Errorf("%w: %s is out of namespace %s", tagmap.ErrConflictingTag, t.Name, r.ns.prefix)
+			}
+		}
+		return r.ns.root.Import(m)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	fresh, err := s.pinned(m)
+	if err != nil || len(fresh) == 0 {
+		return err
+	}
+	if s.sealed {
+		return fmt.Errorf("%w: can't import %s", tagmap.ErrSealed, fresh[0].Name)
+	}
+	r.state.Store(s.pin(fresh))
+	return nil
+}
+
+// pinned validates manifest against the state and returns tags it does not have yet
+func (s *state) pinned(m Manifest) ([]ManifestTag, error) {
+	names := make(map[tagmap.TagName]struct{}, len(m.Tags))
+	indices := make(map[int]struct{}, len(m.Tags))
+	free := make(map[int]struct{}, len(s.free))
+	for _, idx := range s.free {
+		free[idx] = struct{}{}
+	}
+	var fresh []ManifestTag
+	for _, t := range m.Tags {
+		if t.Index < 0 || t.Index > tagmap.MaxTagIndex || t.Generation > tagmap.MaxGeneration {
+			return nil, fmt.Errorf("%w: %s is pinned to %d/%d", tagmap.ErrBadManifest, t.Name, t.Index, t.Generation)
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("%w: %s is pinned twice", tagmap.ErrBadManifest, t.Name)
+		}
+		if _, ok := indices[t.Index]; ok {
+			return nil, fmt.Errorf("%w: index %d is pinned twice", tagmap.ErrBadManifest, t.Index)
+		}
+		names[t.Name], indices[t.Index] = struct{}{}, struct{}{}
+		if tag, ok := s.byName.get(t.Name); ok {
+			if tag != t.Tag() {
+				return nil, fmt.Errorf("%w: %s is registered as %d, not %d", tagmap.ErrConflictingTag, t.Name, tag, t.Tag())
+			}
+			continue
+		}
+		if t.Index < len(s.tags) {
+			if _, ok := free[t.Index]; !ok || t.Generation < s.gen(t.Index) {
+				return nil, fmt.Errorf("%w: %s can't take %d", tagmap.ErrConflictingTag, t.Name, t.Tag())
+			}
+		}
+		fresh = append(fresh, t)
+	}
+	return fresh, nil
+}
+
+// pin returns state with the tags registered, tags must be validated by pinned
+func (s *state) pin(tags []ManifestTag) *state {
+	next := s.clone(len(tags))
+	n := len(s.tags)
+	for _, t := range tags {
+		if t.Index >= n {
+			n = t.Index + 1
+		}
+	}
+	next.tags = make([]tagmap.TagName, n)
+	copy(next.tags, s.tags)
+	next.gens = make([]uint32, n)
+	copy(next.gens, s.gens)
+	taken := make(map[int]struct{}, len(tags))
+	for _, t := range tags {
+		next.tags[t.Index] = t.Name
+		next.gens[t.Index] = t.Generation
+		next.byName = next.byName.set(t.Name, t.Tag())
+		taken[t.Index] = struct{}{}
+	}
+	next.free = nil
+	for _, idx := range s.free {
+		if _, ok := taken[idx]; !ok {
+			next.free = append(next.free, idx)
+		}
+	}
+	// indices that are not pinned are free, the lowest ones are taken first
+	for idx := n - 1; idx >= len(s.tags); idx-- {
+		if _, ok := taken[idx]; !ok {
+			next.free = append(next.free, idx)
+		}
+	}
+	return next
+}
+
+// MarshalBinary encodes manifest as entries of uvarint index, generation, name length and name bytes
+func (m Manifest) MarshalBinary() ([]byte, error) {
+	out := append(make([]byte, 0, 16+len(m.Tags)*16), manifestMagic...)
+	out = append(out, manifestVersion)
+	out = binary.AppendUvarint(out, uint64(len(m.Tags)))
+	for _, t := range m.Tags {
+		out = binary.AppendUvarint(out, uint64(t.Index))
+		out = binary.AppendUvarint(out, uint64(t.Generation))
+		out = binary.AppendUvarint(out, uint64(len(t.Name)))
+		out = append(out, t.Name...)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes manifest encoded by MarshalBinary
+func (m *Manifest) UnmarshalBinary(data []byte) error {
+	if len(data) < len(manifestMagic)+1 || string(data[:len(manifestMagic)]) != manifestMagic {
+		return fmt.Errorf("%w: no magic", tagmap.ErrBadManifest)
+	}
+	if v := data[len(manifestMagic)]; v != manifestVersion {
+		return fmt.Errorf("%w: unsupported version %d", tagmap.ErrBadManifest, v)
+	}
+	data = data[len(manifestMagic)+1:]
+	next := func() uint64 {
+		val, n := binary.Uvarint(data)
+		if n <= 0 {
+			data = nil
+			return 0
+		}
+		data = data[n:]
+		return val
+	}
+	count := next()
+	if data == nil || count > uint64(len(data)) {
+		return fmt.Errorf("%w: truncated", tagmap.ErrBadManifest)
+	}
+	tags := make([]ManifestTag, 0, count)
+	for i := uint64(0); i < count; i++ {
+		idx, gen, size := next(), next(), next()
+		if data == nil || idx > tagmap.MaxTagIndex || gen > tagmap.MaxGeneration || size > uint64(len(data)) {
+			return fmt.Errorf("%w: corrupted entry %d", tagmap.ErrBadManifest, i)
+		}
+		tags = append(tags, ManifestTag{Name: tagmap.TagName(data[:size]), Index: int(idx), Generation: uint32(gen)})
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: trailing data", tagmap.ErrBadManifest)
+	}
+	m.Tags = tags
+	return nil
+}
@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// Meta describes a tag, it is attached to the tag at registration via RegisterTagWithMeta
+type Meta struct {
+	Description string
+	// Unit of tag values, e.g. "ms" or "bytes"
+	Unit string
+	// Default is the value maps created with Defaults option start with
+	Default any
+	Labels  map[string]string
+	// Deprecated tags are reported to OnDeprecated callback when they are looked up by name
+	Deprecated bool
+}
+
+// RegisterTagWithMeta registers new tag along with its metadata
+// !! It will fail if tag is already registered or registry is sealed !!
+func (r *TagRegistry) RegisterTagWithMeta(name tagmap.TagName, meta Meta) tagmap.Tag {
+	tag, err := r.TryRegisterTagWithMeta(name, meta)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+// TryRegisterTagWithMeta does the same as TryRegisterTag, attaching metadata to the tag
+func (r *TagRegistry) TryRegisterTagWithMeta(name tagmap.TagName, meta Meta) (tagmap.Tag, error) {
+	if r.ns != nil {
+		return r.ns.root.TryRegisterTagWithMeta(r.ns.qualify(name), meta)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	_, ok := s.byName.get(name)
+	if ok {
+		return tagmap.UnknownTag, fmt.Errorf("%w: %s", tagmap.ErrDuplicateTag, name)
+	}
+	if meta.Labels != nil {
+		labels := make(map[string]string, len(meta.Labels))
+		for k, v := range meta.Labels {
+			labels[k] = v
+		}
+		meta.Labels = labels
+	}
+	return r.register(s, name, &meta)
+}
+
+// GetMeta returns metadata of the tag, it reports false if tag has none or is not registered.
+// Labels of the result must not be modified.
+func (r *TagRegistry) GetMeta(tag tagmap.Tag) (Meta, bool) {
+	if r.ns != nil {
+		if r.ns.indexOf(tag) < 0 {
+			return Meta{}, false
+		}
+		return r.ns.root.GetMeta(tag)
+	}
+	s := r.load()
+	if idx := tag.Index(); idx < len(s.meta) && s.meta[idx] != nil && s.has(tag) {
+		return *s.meta[idx], true
+	}
+	return Meta{}, false
+}
+
+// Defaults returns default values of all tags that have one
+func (r *TagRegistry) Defaults() map[tagmap.Tag]any {
+	out := make(map[tagmap.Tag]any)
+	for idx, n := 0, r.GetLen(); idx < n; idx++ {
+		tag := r.TagAt(idx)
+		if meta, ok := r.GetMeta(tag); ok && meta.Default != nil {
+			out[tag] = meta.Default
+		}
+	}
+	return out
+}
+
+// DefaultsOf returns default values of all tags that have one as values of type V.
+// Numbers are converted to V if it is a numeric type that holds them exactly, so Meta{Default: 500}
+// fits int64 and time.Duration, it returns tagmap.ErrBadDefault for defaults that do not fit.
+func DefaultsOf[V any](r *TagRegistry) (map[tagmap.Tag]V, error) {
+	defaults := r.Defaults()
+	out := make(map[tagmap.Tag]V, len(defaults))
+	for tag, val := range defaults {
+		v, ok := convertDefault[V](val)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has %T default %v, map holds %s",
+				tagmap.ErrBadDefault, r.GetName(tag), val, val, reflect.TypeOf((*V)(nil)).Elem())
+		}
+		out[tag] = v
+	}
+	return out, nil
+}
+
+func convertDefault[V any](val any) (V, bool) {
+	if v, ok := val.(V); ok {
+		return v, true
+	}
+	from, to := reflect.ValueOf(val), reflect.TypeOf((*V)(nil)).Elem()
+	if !isNumber(from.Kind()) || !isNumber(to.Kind()) {
+		return *new(V), false
+	}
+	// converting back reveals overflows and lost fractions, sign check reveals wrapping between signed and unsigned
+	v := from.Convert(to)
+	if v.Convert(from.Type()).Interface() != val || isNegative(v) != isNegative(from) {
+		return *new(V), false
+	}
+	return v.Interface().(V), true
+}
+
+func isNumber(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+func isNegative(v reflect.Value) bool {
+	switch {
+	case v.Kind() <= reflect.Int64:
+		return v.Int() < 0
+	case v.Kind() <= reflect.Uintptr:
+		return false
+	}
+	return v.Float() < 0
+}
+
+// OnDeprecated sets callback that is called whenever a deprecated tag is looked up by name (GetTag, LookupTag),
+// e.g. by Map.GetByName. It is called synchronously, so it must be fast. Namespaces share callback with the root.
+func (r *TagRegistry) OnDeprecated(fn func(name tagmap.TagName)) {
+	if r.ns != nil {
+		r.ns.root.OnDeprecated(fn)
+		return
+	}
+	r.onDeprecated.Store(&fn)
+}
+
+func (r *TagRegistry) lookedUp(s *state, name tagmap.TagName) {
+	if s.deprecated == nil {
+		return
+	}
+	if _, ok := s.deprecated[name]; !ok {
+		return
+	}
+	if fn := r.onDeprecated.Load(); fn != nil {
+		(*fn)(name)
+	}
+}
+
+// setMeta attaches metadata to the tag at index in a cloned state, nil meta detaches it
+func (s *state) setMeta(idx int, name tagmap.TagName, meta *Meta) {
+	if meta == nil && idx >= len(s.meta) {
+		return
+	}
+	var metas []*Meta
+	if idx >= len(s.meta) {
+		// readers of the old state never look past its length, so appending is safe
+		metas = s.meta
+		for len(metas) < idx {
+			metas = append(metas, nil)
+		}
+		metas = append(metas, nil)
+	} else {
+		metas = make([]*Meta, len(s.tags))
+		copy(metas, s.meta)
+	}
+	prev := metas[idx]
+	metas[idx] = meta
+	s.meta = metas
+	if deprecated := meta != nil && meta.Deprecated; deprecated != (prev != nil && prev.Deprecated) {
+		s.deprecate(name, deprecated)
+	}
+}
+
+func (s *state) deprecate(name tagmap.TagName, deprecated bool) {
+	names := make(map[tagmap.TagName]struct{}, len(s.deprecated)+1)
+	for name := range s.deprecated {
+		names[name] = struct{}{}
+	}
+	if deprecated {
+		names[name] = struct{}{}
+	} else {
+		delete(names, name)
+	}
+	if len(names) == 0 {
+		names = nil
+	}
+	s.deprecated = names
+}
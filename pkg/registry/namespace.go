@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// namespace is a view of tags of the root registry whose names start with prefix.
+// Tags of the namespace are the tags of the root, while maps built from the namespace place them
+// at their own dense indices, assigned in order the namespace sees them. Indices are never reused,
+// index of an unregistered tag stays empty, so that maps can not confuse it with another tag.
+type namespace struct {
+	root   *TagRegistry
+	prefix tagmap.TagName
+	mu     sync.Mutex
+	view   atomic.Pointer[view]
+}
+
+// view is built from a root state, it catches up when the root changes and a tag is missing from it
+type view struct {
+	from *state
+	// tags holds tags by namespace index
+	tags []tagmap.Tag
+	// names holds full names by namespace index, names of unregistered tags are empty
+	names []tagmap.TagName
+	// local maps index of the tag in the root to namespace index, -1 if tag is not in namespace
+	local []int32
+}
+
+// Namespace returns registry of tags whose names start with prefix followed by a dot, it shares tags with r.
+// Registering tags in the namespace registers them in r with qualified names,
+// while lookups take and return full names, so maps built from the namespace are addressable by full names.
+// Such maps are sized to the tags of the namespace, tags that do not belong to it are unknown to them.
+// Namespaces can be nested.
+func (r *TagRegistry) Namespace(prefix tagmap.TagName) *TagRegistry {
+	root := r
+	if r.ns != nil {
+		root, prefix = r.ns.root, r.ns.qualify(prefix)
+	}
+	ns := &namespace{root: root, prefix: prefix + "."}
+	ns.view.Store(&view{})
+	ns.sync()
+	return &TagRegistry{ns: ns, unregistered: root.unregistered}
+}
+
+// Prefix returns prefix of the namespace including the trailing dot, it is empty for the root registry
+func (r *TagRegistry) Prefix() tagmap.TagName {
+	if r.ns != nil {
+		return r.ns.prefix
+	}
+	return ""
+}
+
+func (ns *namespace) qualify(name tagmap.TagName) tagmap.TagName {
+	return ns.prefix + name
+}
+
+func (ns *namespace) qualifyAll(names []tagmap.TagName) []tagmap.TagName {
+	out := make([]tagmap.TagName, len(names))
+	for i, name := range names {
+		out[i] = ns.qualify(name)
+	}
+	return out
+}
+
+func (ns *namespace) contains(name tagmap.TagName) bool {
+	return strings.HasPrefix(string(name), string(ns.prefix))
+}
+
+// sync brings view up to date with the root, keeping indices of tags it already has.
+// Up to date view is returned without locking, so reading calls pay for catching up only after the root changed.
+// Catching up looks only at indices appended to the root and at indices changed in place since the view was built,
+// tags and names are appended to in place same as tags of the root state, they are copied only if a tag changed.
+func (ns *namespace) sync() *view {
+	if v := ns.view.Load(); v.from == ns.root.load() {
+		return v
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	// view could be updated while waiting for the lock
+	v := ns.view.Load()
+	s := ns.root.load()
+	if v.from == s {
+		return v
+	}
+	next := &view{from: s, tags: v.tags, names: v.names, local: v.local}
+	appended, changed := 0, s.changed
+	if v.from != nil {
+		appended, changed = len(v.from.tags), s.changed[len(v.from.changed):]
+	}
+	if len(changed) > 0 {
+		next.names = append([]tagmap.TagName(nil), v.names...)
+		next.local = append([]int32(nil), v.local...)
+	}
+	for _, idx := range changed {
+		// appended indices are looked at below
+		if idx < appended {
+			ns.update(next, s, idx)
+		}
+	}
+	for idx := appended; idx < len(s.tags); idx++ {
+		next.local = append(next.local, -1)
+		ns.update(next, s, idx)
+	}
+	ns.view.Store(next)
+	return next
+}
+
+// update brings namespace index of the tag at root index idx in line with the state s
+func (ns *namespace) update(next *view, s *state, idx int) {
+	tag := tagmap.MakeTag(idx, s.gen(idx))
+	slot := next.local[idx]
+	if slot >= 0 && next.tags[slot] == tag {
+		return
+	}
+	// namespace index of the tag that held idx before stays empty
+	if slot >= 0 {
+		next.names[slot] = ""
+		next.local[idx] = -1
+	}
+	if name := s.tags[idx]; ns.contains(name) && s.has(tag) {
+		next.local[idx] = int32(len(next.tags))
+		next.tags = append(next.tags, tag)
+		next.names = append(next.names, name)
+	}
+}
+
+func (v *view) indexOf(tag tagmap.Tag) (int, bool) {
+	if idx := tag.Index(); idx < len(v.local) {
+		if slot := v.local[idx]; slot >= 0 && v.tags[slot] == tag {
+			return int(slot), true
+		}
+	}
+	return -1, false
+}
+
+func (ns *namespace) indexOf(tag tagmap.Tag) int {
+	v := ns.view.Load()
+	for {
+		if slot, ok := v.indexOf(tag); ok || v.from == ns.root.load() {
+			return slot
+		}
+		v = ns.sync()
+	}
+}
+
+func (ns *namespace) has(tag tagmap.Tag) bool {
+	return ns.indexOf(tag) >= 0 && ns.root.Has(tag)
+}
+
+func (ns *namespace) tagAt(idx int) tagmap.Tag {
+	v := ns.view.Load()
+	if idx >= len(v.tags) {
+		v = ns.sync()
+	}
+	if idx < 0 || idx >= len(v.tags) || !ns.root.Has(v.tags[idx]) {
+		return tagmap.UnknownTag
+	}
+	return v.tags[idx]
+}
+
+func (ns *namespace) lookupTag(name tagmap.TagName) (tagmap.Tag, error) {
+	if ns.contains(name) {
+		if tag := ns.root.GetTag(name); tag != tagmap.UnknownTag {
+			return tag, nil
+		}
+	}
+	return tagmap.UnknownTag, fmt.Errorf("%w: %s", tagmap.ErrUnknownTag, name)
+}
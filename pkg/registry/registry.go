@@ -1,55 +1,420 @@
 package registry
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
 	"github.com/go-auxiliaries/tagmap"
 )
 
+// TagRegistry is safe for concurrent use.
+// Registration is serialized, while lookups (GetTag, GetName, GetLen) are lock-free:
+// they read an immutable snapshot that is replaced on every registration.
+// Snapshots share the name index (see nameIndex) and the names appended so far,
+// so registering a tag copies only a few entries of the index, not the whole of it.
+// Unregister and registrations that reuse an unregistered index still copy the names, they are expected to be rare.
+//
+// Once all tags are registered the registry can be sealed via Seal,
+// after that registering new tags fails with tagmap.ErrSealed.
+//
+// Tags can be unregistered via Unregister, index of such tag is reused by the next registered one with
+// generation incremented (see tagmap.Tag.Generation), so maps do not confuse the old tag with the new one.
+//
+// Child registries that share tags with the parent and see only some of them are created via Namespace.
 type TagRegistry struct {
-	tags    []tagmap.TagName
-	backMap map[tagmap.TagName]int
+	mu           sync.Mutex
+	state        atomic.Pointer[state]
+	onDeprecated atomic.Pointer[func(name tagmap.TagName)]
+	// unregistered counts calls of Unregister, see Unregistered, namespaces share it with the root
+	unregistered *atomic.Uint64
+	// ns is set for namespaces, they have no state of their own
+	ns *namespace
+}
+
+type state struct {
+	// tags holds names by index, names of unregistered tags are empty
+	tags   []tagmap.TagName
+	byName nameIndex
+	// gens holds the current generation of every index, it is shorter than tags when the rest are zero
+	gens []uint32
+	// free holds unregistered indices that are ready for reuse
+	free []int
+	// changed logs indices whose tag changed in place, that is unregistered or reused ones,
+	// so that namespaces catch up with the root by looking only at them and at appended indices.
+	// It is shared by subsequent states same as tags, every state sees only its own length of it.
+	changed []int
+	// meta holds metadata by index, it is shorter than tags when the rest have none
+	meta []*Meta
+	// deprecated is nil unless some tags are deprecated
+	deprecated map[tagmap.TagName]struct{}
+	sealed     bool
 }
 
 func New() *TagRegistry {
-	return &TagRegistry{
-		tags:    make([]tagmap.TagName, 0),
-		backMap: make(map[tagmap.TagName]int, 0),
-	}
+	r := &TagRegistry{unregistered: new(atomic.Uint64)}
+	r.state.Store(&state{
+		tags: make([]tagmap.TagName, 0),
+	})
+	return r
+}
+
+func (r *TagRegistry) load() *state {
+	return r.state.Load()
 }
 
+// RegisterTag registers new tag
+// !! It will fail if tag is already registered or registry is sealed !!
 func (r *TagRegistry) RegisterTag(name tagmap.TagName) tagmap.Tag {
-	_, ok := r.backMap[name]
+	tag, err := r.TryRegisterTag(name)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+// TryRegisterTag registers new tag, it returns tagmap.ErrDuplicateTag if tag is already registered
+// and tagmap.ErrSealed if registry is sealed
+func (r *TagRegistry) TryRegisterTag(name tagmap.TagName) (tagmap.Tag, error) {
+	if r.ns != nil {
+		return r.ns.root.TryRegisterTag(r.ns.qualify(name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	_, ok := s.byName.get(name)
 	if ok {
-		panic("tag with name " + name + " is already registered")
+		return tagmap.UnknownTag, fmt.Errorf("%w: %s", tagmap.ErrDuplicateTag, name)
 	}
-	idx := len(r.tags)
-	r.tags = append(r.tags, name)
-	r.backMap[name] = idx
-	return tagmap.Tag(idx)
+	return r.register(s, name, nil)
 }
 
+// RegisterOrReuseTag registers new tag or returns already registered one
+// !! It will fail if tag is not registered and registry is sealed !!
 func (r *TagRegistry) RegisterOrReuseTag(name tagmap.TagName) tagmap.Tag {
-	idx, ok := r.backMap[name]
+	tag, err := r.TryRegisterOrReuseTag(name)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+// TryRegisterOrReuseTag registers new tag or returns already registered one,
+// it returns tagmap.ErrSealed if tag is not registered and registry is sealed
+func (r *TagRegistry) TryRegisterOrReuseTag(name tagmap.TagName) (tagmap.Tag, error) {
+	if r.ns != nil {
+		return r.ns.root.TryRegisterOrReuseTag(r.ns.qualify(name))
+	}
+	tag, ok := r.load().byName.get(name)
 	if ok {
-		return tagmap.Tag(idx)
+		return tag, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	tag, ok = s.byName.get(name)
+	if ok {
+		return tag, nil
+	}
+	return r.register(s, name, nil)
+}
+
+// RegisterOrReuseTags does the same as RegisterOrReuseTag for every name,
+// but publishes all new tags at once
+// !! It will fail if any tag is not registered and registry is sealed !!
+func (r *TagRegistry) RegisterOrReuseTags(names ...tagmap.TagName) []tagmap.Tag {
+	if r.ns != nil {
+		return r.ns.root.RegisterOrReuseTags(r.ns.qualifyAll(names)...)
+	}
+	out, err := r.tryRegisterOrReuseTags(names)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// tryRegisterOrReuseTags does the same as RegisterOrReuseTags, returning error instead of failing,
+// nothing is registered then
+func (r *TagRegistry) tryRegisterOrReuseTags(names []tagmap.TagName) ([]tagmap.Tag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	next := s
+	out := make([]tagmap.Tag, len(names))
+	for i, name := range names {
+		tag, ok := next.byName.get(name)
+		if !ok {
+			if s.sealed {
+				return nil, fmt.Errorf("%w: can't register %s", tagmap.ErrSealed, name)
+			}
+			if next == s {
+				next = s.clone(len(names) - i)
+			}
+			var err error
+			if tag, err = next.add(name); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = tag
+	}
+	if next != s {
+		r.state.Store(next)
+	}
+	return out, nil
+}
+
+// register must be called with r.mu held
+func (r *TagRegistry) register(s *state, name tagmap.TagName, meta *Meta) (tagmap.Tag, error) {
+	if s.sealed {
+		return tagmap.UnknownTag, fmt.Errorf("%w: can't register %s", tagmap.ErrSealed, name)
+	}
+	next := s.clone(1)
+	tag, err := next.add(name)
+	if err != nil {
+		return tagmap.UnknownTag, err
+	}
+	if meta != nil {
+		next.setMeta(tag.Index(), name, meta)
+	}
+	r.state.Store(next)
+	return tag, nil
+}
+
+// add registers name in a cloned state, reusing an unregistered index if there is one
+func (s *state) add(name tagmap.TagName) (tagmap.Tag, error) {
+	idx := len(s.tags)
+	if n := len(s.free); n > 0 {
+		idx = s.free[n-1]
+		s.free = s.free[:n-1]
+		s.tags[idx] = name
+		s.changed = append(s.changed, idx)
+	} else if idx > tagmap.MaxTagIndex {
+		return tagmap.UnknownTag, fmt.Errorf("%w: can't register %s", tagmap.ErrTooManyTags, name)
+	} else {
+		s.tags = append(s.tags, name)
+	}
+	tag := tagmap.MakeTag(idx, s.gen(idx))
+	s.byName = s.byName.set(name, tag)
+	return tag, nil
+}
+
+// Unregister removes tag, its index is reused by the next registered tag.
+// Values maps keep for the tag are not visible via the new tag, they are dropped once it is written.
+// Maps read nothing via the unregistered tag and fail to write via it, see tagmap.ErrStaleTag.
+// !! It will fail if tag is not registered or registry is sealed !!
+func (r *TagRegistry) Unregister(name tagmap.TagName) {
+	if err := r.TryUnregister(name); err != nil {
+		panic(err)
+	}
+}
+
+// TryUnregister does the same as Unregister, it returns tagmap.ErrUnknownTag if tag is not registered
+// and tagmap.ErrSealed if registry is sealed
+func (r *TagRegistry) TryUnregister(name tagmap.TagName) error {
+	if r.ns != nil {
+		return r.ns.root.TryUnregister(r.ns.qualify(name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	tag, ok := s.byName.get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", tagmap.ErrUnknownTag, name)
+	}
+	if s.sealed {
+		return fmt.Errorf("%w: can't unregister %s", tagmap.ErrSealed, name)
+	}
+	next := s.clone(0)
+	next.byName = next.byName.delete(name)
+	idx := tag.Index()
+	// indices are changed in place, so readers of the old state must not see it
+	if len(s.free) == 0 {
+		next.tags = append([]tagmap.TagName(nil), s.tags...)
+		next.free = make([]int, 0, 1)
+	}
+	next.gens = make([]uint32, len(s.tags))
+	copy(next.gens, s.gens)
+	next.tags[idx] = ""
+	next.changed = append(next.changed, idx)
+	next.setMeta(idx, name, nil)
+	if gen := next.gens[idx]; gen < tagmap.MaxGeneration {
+		next.gens[idx] = gen + 1
+		next.free = append(next.free, idx)
+	}
+	// counted before the tag is gone, so maps that see no unregistered tags never miss a stale one
+	r.unregistered.Add(1)
+	r.state.Store(next)
+	return nil
+}
+
+// Unregistered returns number of tags unregistered so far, while it is zero no tag can be stale,
+// so maps skip checking tags via Has. Sealed registry can not unregister tags, so the number never changes then.
+func (r *TagRegistry) Unregistered() uint64 {
+	return r.unregistered.Load()
+}
+
+// Seal declares that registration phase is over, no tags can be registered after that.
+// Maps created from a sealed registry never need to grow and can cache tag names,
+// they skip checking for stale tags too unless some tags were unregistered before sealing.
+// Sealing a namespace seals the whole registry.
+func (r *TagRegistry) Seal() {
+	if r.ns != nil {
+		r.ns.root.Seal()
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	if s.sealed {
+		return
+	}
+	next := s.clone(0)
+	next.tags = s.tags[:len(s.tags):len(s.tags)]
+	// names never change from now on, so lookups should not pay for walking the trie of recent names
+	if s.byName.recent != nil {
+		next.byName = s.byName.rebuild()
+	}
+	next.sealed = true
+	r.state.Store(next)
+}
+
+// Frozen reports whether registry is sealed
+func (r *TagRegistry) Frozen() bool {
+	if r.ns != nil {
+		return r.ns.root.Frozen()
+	}
+	return r.load().sealed
+}
+
+// clone shares everything with s: the name index is persistent and
+// readers of the old state never look past length of the tags slice, so appending to it is safe.
+// Reusing unregistered indices changes tags in place, so then the slices are copied.
+func (s *state) clone(extra int) *state {
+	next := &state{
+		tags:       s.tags,
+		byName:     s.byName,
+		gens:       s.gens,
+		free:       s.free,
+		changed:    s.changed,
+		meta:       s.meta,
+		deprecated: s.deprecated,
+	}
+	if len(s.free) > 0 {
+		next.tags = append(make([]tagmap.TagName, 0, len(s.tags)+extra), s.tags...)
+		next.free = append([]int(nil), s.free...)
+	}
+	return next
+}
+
+func (s *state) gen(idx int) uint32 {
+	if idx < len(s.gens) {
+		return s.gens[idx]
 	}
-	idx = len(r.tags)
-	r.tags = append(r.tags, name)
-	r.backMap[name] = idx
-	return tagmap.Tag(idx)
+	return 0
 }
 
+// has reports whether tag is registered and was not unregistered since
+func (s *state) has(tag tagmap.Tag) bool {
+	idx := tag.Index()
+	if tag < 0 || idx >= len(s.tags) || s.gen(idx) != tag.Generation() {
+		return false
+	}
+	// names of unregistered tags are empty, so only a tag registered with empty name needs a lookup
+	if s.tags[idx] != "" {
+		return true
+	}
+	live, ok := s.byName.get("")
+	return ok && live == tag
+}
+
+// GetName returns tag name, it returns empty name if tag is unknown or was unregistered
 func (r *TagRegistry) GetName(tag tagmap.Tag) tagmap.TagName {
-	return r.tags[tag]
+	if r.ns != nil {
+		if r.ns.indexOf(tag) < 0 {
+			return ""
+		}
+		return r.ns.root.GetName(tag)
+	}
+	s := r.load()
+	idx := tag.Index()
+	if tag < 0 || idx >= len(s.tags) || s.gen(idx) != tag.Generation() {
+		return ""
+	}
+	return s.tags[idx]
 }
 
 func (r *TagRegistry) GetTag(name tagmap.TagName) tagmap.Tag {
-	idx, ok := r.backMap[name]
+	if r.ns != nil {
+		tag, _ := r.ns.lookupTag(name)
+		return tag
+	}
+	s := r.load()
+	tag, ok := s.byName.get(name)
 	if ok {
-		return tagmap.Tag(idx)
+		r.lookedUp(s, name)
+		return tag
 	}
 	return tagmap.UnknownTag
 }
 
+// LookupTag returns tag by name, it returns tagmap.ErrUnknownTag if there is no such tag
+func (r *TagRegistry) LookupTag(name tagmap.TagName) (tagmap.Tag, error) {
+	if r.ns != nil {
+		return r.ns.lookupTag(name)
+	}
+	s := r.load()
+	tag, ok := s.byName.get(name)
+	if ok {
+		r.lookedUp(s, name)
+		return tag, nil
+	}
+	return tagmap.UnknownTag, fmt.Errorf("%w: %s", tagmap.ErrUnknownTag, name)
+}
+
+// Has reports whether tag is registered, tags that were unregistered are not
+func (r *TagRegistry) Has(tag tagmap.Tag) bool {
+	if r.ns != nil {
+		return r.ns.has(tag)
+	}
+	return r.load().has(tag)
+}
+
+// IndexOf returns index maps built from the registry keep tag value at, it is tag.Index() unless registry is a namespace,
+// it returns -1 if tag does not belong to the namespace
+func (r *TagRegistry) IndexOf(tag tagmap.Tag) int {
+	if r.ns != nil {
+		return r.ns.indexOf(tag)
+	}
+	return tag.Index()
+}
+
+// TagAt returns tag registered at index (see IndexOf), it returns tagmap.UnknownTag if tag at index was unregistered
+func (r *TagRegistry) TagAt(idx int) tagmap.Tag {
+	if r.ns != nil {
+		return r.ns.tagAt(idx)
+	}
+	s := r.load()
+	if tag := tagmap.MakeTag(idx, s.gen(idx)); s.has(tag) {
+		return tag
+	}
+	return tagmap.UnknownTag
+}
+
+// Names returns names of all registered tags indexed by tag index, names of unregistered tags are empty,
+// the result must not be modified
+func (r *TagRegistry) Names() []tagmap.TagName {
+	if r.ns != nil {
+		names := r.ns.sync().names
+		return names[:len(names):len(names)]
+	}
+	s := r.load()
+	return s.tags[:len(s.tags):len(s.tags)]
+}
+
+// GetLen returns number of tag indices, including indices of unregistered tags
 func (r *TagRegistry) GetLen() int {
-	return len(r.tags)
+	if r.ns != nil {
+		return len(r.ns.sync().tags)
+	}
+	return len(r.load().tags)
 }
@@ -1,7 +1,11 @@
 package registry_test
 
 import (
+	"encoding/json"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-auxiliaries/tagmap"
 
@@ -21,3 +25,387 @@ func TestBasic(t *testing.T) {
 	assert.Equal(t, tagmap.TagName("tag2"), r.GetName(tag2))
 	assert.Equal(t, tagmap.TagName("tag3"), r.GetName(tag3))
 }
+
+func TestRegisterOrReuseTags(t *testing.T) {
+	r := registry.New()
+	var tag1 = r.RegisterTag("tag1")
+	tags := r.RegisterOrReuseTags("tag1", "tag2", "tag3", "tag2")
+	assert.Equal(t, []tagmap.Tag{tag1, 1, 2, 1}, tags)
+	assert.Equal(t, 3, r.GetLen())
+	assert.Equal(t, tagmap.TagName("tag3"), r.GetName(tags[2]))
+	assert.Equal(t, tags[1], r.RegisterOrReuseTag("tag2"))
+}
+
+func TestParallel(t *testing.T) {
+	r := registry.New()
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			for k := 0; k < 100; k++ {
+				name := tagmap.TagName(strconv.Itoa(k))
+				tag := r.RegisterOrReuseTag(name)
+				assert.Equal(t, tag, r.GetTag(name))
+				assert.Equal(t, name, r.GetName(tag))
+				r.GetTag(tagmap.TagName(strconv.Itoa(k + n)))
+				r.GetLen()
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+	assert.Equal(t, 100, r.GetLen())
+}
+
+func TestManyTags(t *testing.T) {
+	r := registry.New()
+	const n = 20000
+	for k := 0; k < n; k++ {
+		assert.Equal(t, tagmap.Tag(k), r.RegisterTag(tagmap.TagName(strconv.Itoa(k))))
+	}
+	for k := 0; k < n; k += 100 {
+		r.Unregister(tagmap.TagName(strconv.Itoa(k)))
+	}
+	for k := 0; k < n; k++ {
+		tag, err := r.LookupTag(tagmap.TagName(strconv.Itoa(k)))
+		if k%100 == 0 {
+			assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+		} else {
+			assert.Equal(t, tagmap.Tag(k), tag)
+		}
+	}
+	assert.Equal(t, n-n/100, len(r.Manifest().Tags))
+}
+
+func BenchmarkRegisterTag(b *testing.B) {
+	r := registry.New()
+	for n := 0; n < b.N; n++ {
+		r.RegisterTag(tagmap.TagName(strconv.Itoa(n)))
+	}
+}
+
+func BenchmarkNamespaceGetLen(b *testing.B) {
+	r := registry.New()
+	ns := r.Namespace("ns")
+	ns.RegisterTag("tag")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ns.GetLen()
+		}
+	})
+}
+
+// BenchmarkNamespaceRegister registers tags in a namespace of a large root one by one,
+// namespace catches up with each of them without looking at the rest of the root
+func BenchmarkNamespaceRegister(b *testing.B) {
+	r := registry.New()
+	for i := 0; i < 1_000_000; i++ {
+		r.RegisterTag(tagmap.TagName("root" + strconv.Itoa(i)))
+	}
+	ns := r.Namespace("ns")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns.RegisterTag(tagmap.TagName("tag" + strconv.Itoa(i)))
+		ns.GetLen()
+	}
+}
+
+func TestSeal(t *testing.T) {
+	r := registry.New()
+	var tag1 = r.RegisterTag("tag1")
+	assert.False(t, r.Frozen())
+	r.Seal()
+	r.Seal()
+	assert.True(t, r.Frozen())
+
+	_, err := r.TryRegisterTag("tag2")
+	assert.ErrorIs(t, err, tagmap.ErrSealed)
+	_, err = r.TryRegisterOrReuseTag("tag2")
+	assert.ErrorIs(t, err, tagmap.ErrSealed)
+	assert.Panics(t, func() { r.RegisterTag("tag2") })
+	assert.Panics(t, func() { r.RegisterOrReuseTag("tag2") })
+	assert.Panics(t, func() { r.RegisterOrReuseTags("tag1", "tag2") })
+
+	tag, err := r.TryRegisterOrReuseTag("tag1")
+	assert.NoError(t, err)
+	assert.Equal(t, tag1, tag)
+	assert.Equal(t, []tagmap.Tag{tag1}, r.RegisterOrReuseTags("tag1"))
+	_, err = r.TryRegisterTag("tag1")
+	assert.ErrorIs(t, err, tagmap.ErrDuplicateTag)
+	assert.Equal(t, []tagmap.TagName{"tag1"}, r.Names())
+	assert.Equal(t, 1, r.GetLen())
+
+	// sealing moves recently registered names to the plain map, lookups find the same tags
+	r = registry.New()
+	tags := make([]tagmap.Tag, 100)
+	for i := range tags {
+		tags[i] = r.RegisterTag(tagmap.TagName("tag" + strconv.Itoa(i)))
+	}
+	r.Unregister("tag50")
+	r.Seal()
+	for i, tag := range tags {
+		if i == 50 {
+			assert.Equal(t, tagmap.UnknownTag, r.GetTag("tag50"))
+			continue
+		}
+		assert.Equal(t, tag, r.GetTag(tagmap.TagName("tag"+strconv.Itoa(i))))
+	}
+}
+
+func TestLookupTag(t *testing.T) {
+	r := registry.New()
+	var tag1 = r.RegisterTag("tag1")
+	tag, err := r.LookupTag("tag1")
+	assert.NoError(t, err)
+	assert.Equal(t, tag1, tag)
+	tag, err = r.LookupTag("tag2")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.Equal(t, tagmap.UnknownTag, tag)
+
+	_, err = r.TryRegisterTag("tag1")
+	assert.ErrorIs(t, err, tagmap.ErrDuplicateTag)
+	defer func() {
+		assert.ErrorIs(t, recover().(error), tagmap.ErrDuplicateTag)
+	}()
+	r.RegisterTag("tag1")
+}
+
+func TestUnregister(t *testing.T) {
+	r := registry.New()
+	var tag1 = r.RegisterTag("tag1")
+	var tag2 = r.RegisterTag("tag2")
+	ns := r.Namespace("ns")
+	assert.Equal(t, uint64(0), r.Unregistered())
+	r.Unregister("tag1")
+	assert.Equal(t, uint64(1), r.Unregistered())
+	assert.Equal(t, uint64(1), ns.Unregistered())
+	assert.ErrorIs(t, r.TryUnregister("tag1"), tagmap.ErrUnknownTag)
+	assert.Equal(t, uint64(1), r.Unregistered())
+	assert.Equal(t, tagmap.UnknownTag, r.GetTag("tag1"))
+	assert.Equal(t, tagmap.TagName(""), r.GetName(tag1))
+	assert.False(t, r.Has(tag1))
+	assert.True(t, r.Has(tag2))
+	assert.Equal(t, tagmap.UnknownTag, r.TagAt(tag1.Index()))
+
+	tags := r.RegisterOrReuseTags("tag3", "tag4")
+	assert.Equal(t, tag1.Index(), tags[0].Index())
+	assert.Equal(t, uint32(1), tags[0].Generation())
+	assert.Equal(t, tagmap.Tag(2), tags[1])
+	assert.Equal(t, tags[0], r.TagAt(tag1.Index()))
+	assert.Equal(t, tagmap.TagName("tag3"), r.GetName(tags[0]))
+	assert.Equal(t, tagmap.TagName(""), r.GetName(tag1))
+	assert.False(t, r.Has(tag1))
+	assert.Equal(t, []tagmap.TagName{"tag3", "tag2", "tag4"}, r.Names())
+	assert.Equal(t, 3, r.GetLen())
+
+	r.Unregister("tag3")
+	tag1 = r.RegisterTag("tag1")
+	assert.Equal(t, tagmap.MakeTag(0, 2), tag1)
+	r.Seal()
+	assert.ErrorIs(t, r.TryUnregister("tag1"), tagmap.ErrSealed)
+	assert.Panics(t, func() { r.Unregister("tag2") })
+}
+
+func TestNamespace(t *testing.T) {
+	r := registry.New()
+	var root = r.RegisterTag("root")
+	http := r.Namespace("http")
+	get := http.RegisterTag("get")
+	client := http.Namespace("client")
+	tags := client.RegisterOrReuseTags("get", "post")
+	db := r.Namespace("db")
+	query := db.RegisterTag("query")
+	late := r.RegisterTag("http.late")
+
+	assert.Equal(t, tagmap.TagName("http."), http.Prefix())
+	assert.Equal(t, tagmap.TagName("http.client."), client.Prefix())
+	assert.Equal(t, get, r.GetTag("http.get"))
+	assert.Equal(t, tags[1], r.GetTag("http.client.post"))
+	assert.Equal(t, get, http.GetTag("http.get"))
+	assert.Equal(t, tagmap.UnknownTag, http.GetTag("get"))
+	assert.Equal(t, tagmap.UnknownTag, http.GetTag("db.query"))
+	_, err := db.LookupTag("http.get")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.Equal(t, tagmap.TagName("db.query"), db.GetName(query))
+	assert.Equal(t, tagmap.TagName(""), db.GetName(get))
+
+	assert.Equal(t, []tagmap.TagName{"http.get", "http.client.get", "http.client.post", "http.late"}, http.Names())
+	assert.Equal(t, []tagmap.TagName{"http.client.get", "http.client.post"}, client.Names())
+	assert.Equal(t, 1, db.GetLen())
+	assert.Equal(t, 6, r.GetLen())
+	assert.Equal(t, 3, http.IndexOf(late))
+	assert.Equal(t, late, http.TagAt(3))
+	assert.Equal(t, -1, http.IndexOf(root))
+	assert.False(t, http.Has(query))
+	assert.True(t, http.Has(get))
+
+	names := http.Names()
+	http.Unregister("get")
+	assert.False(t, r.Has(get))
+	assert.Equal(t, tagmap.UnknownTag, http.TagAt(0))
+	put := http.RegisterTag("put")
+	assert.Equal(t, get.Index(), put.Index())
+	assert.Equal(t, 4, http.IndexOf(put))
+	assert.Equal(t, 5, http.GetLen())
+	assert.Equal(t, []tagmap.TagName{"", "http.client.get", "http.client.post", "http.late", "http.put"}, http.Names())
+	assert.Equal(t, tagmap.TagName("http.get"), names[0])
+	r.Unregister("http.put")
+	other := r.RegisterTag("other")
+	assert.Equal(t, put.Index(), other.Index())
+	assert.False(t, http.Has(put))
+	assert.Equal(t, -1, http.IndexOf(other))
+	assert.Equal(t, tagmap.TagName(""), http.Names()[4])
+
+	http.Seal()
+	assert.True(t, r.Frozen())
+	assert.True(t, db.Frozen())
+}
+
+func TestManifest(t *testing.T) {
+	r := registry.New()
+	r.RegisterOrReuseTags("tag1", "tag2", "tag3", "tag4")
+	r.Unregister("tag1")
+	r.Unregister("tag3")
+	tag5 := r.RegisterTag("tag5")
+	manifest := r.Manifest()
+	assert.Equal(t, []registry.ManifestTag{{Name: "tag2", Index: 1}, {Name: "tag5", Index: 2, Generation: 1}, {Name: "tag4", Index: 3}}, manifest.Tags)
+
+	data, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	fromJSON := registry.Manifest{}
+	assert.NoError(t, json.Unmarshal(data, &fromJSON))
+	data, err = manifest.MarshalBinary()
+	assert.NoError(t, err)
+	fromBinary := registry.Manifest{}
+	assert.NoError(t, fromBinary.UnmarshalBinary(data))
+	assert.Equal(t, manifest, fromJSON)
+	assert.Equal(t, manifest, fromBinary)
+	assert.ErrorIs(t, fromBinary.UnmarshalBinary(data[:len(data)-1]), tagmap.ErrBadManifest)
+
+	peer := registry.New()
+	local := peer.RegisterTag("tag4")
+	assert.ErrorIs(t, peer.Import(manifest), tagmap.ErrConflictingTag)
+	assert.Equal(t, []tagmap.Tag{local}, peer.RegisterOrReuseTags("tag4"))
+
+	peer = registry.New()
+	assert.NoError(t, peer.Import(manifest))
+	assert.NoError(t, peer.Import(manifest))
+	assert.Equal(t, tag5, peer.GetTag("tag5"))
+	assert.Equal(t, manifest, peer.Manifest())
+	assert.Equal(t, tagmap.Tag(0), peer.RegisterTag("tag6"))
+	assert.Equal(t, tagmap.Tag(4), peer.RegisterTag("tag7"))
+	assert.ErrorIs(t, peer.Import(registry.Manifest{Tags: []registry.ManifestTag{{Name: "tag8", Index: 4}}}), tagmap.ErrConflictingTag)
+	peer.Seal()
+	assert.ErrorIs(t, peer.Import(registry.Manifest{Tags: []registry.ManifestTag{{Name: "tag8", Index: 5}}}), tagmap.ErrSealed)
+	assert.NoError(t, peer.Import(manifest))
+
+	http := registry.New().Namespace("http")
+	assert.ErrorIs(t, http.Import(manifest), tagmap.ErrConflictingTag)
+	assert.NoError(t, http.Import(registry.Manifest{Tags: []registry.ManifestTag{{Name: "http.get", Index: 2}}}))
+	assert.Equal(t, tagmap.Tag(2), http.GetTag("http.get"))
+	assert.Equal(t, []tagmap.TagName{"http.get"}, http.Names())
+}
+
+func TestRemap(t *testing.T) {
+	from := registry.New()
+	tags := from.RegisterOrReuseTags("a", "b", "c", "http.d")
+	to := registry.New()
+	c := to.RegisterTag("c")
+	a := to.RegisterTag("a")
+
+	rm, err := registry.Remap(from, to, registry.DropMissing)
+	assert.NoError(t, err)
+	assert.Equal(t, from, rm.From())
+	assert.Equal(t, to, rm.To())
+	assert.Equal(t, []tagmap.Tag{a, tagmap.UnknownTag, c, tagmap.UnknownTag}, []tagmap.Tag{rm.Tag(tags[0]), rm.Tag(tags[1]), rm.Tag(tags[2]), rm.Tag(tags[3])})
+	assert.Equal(t, tagmap.UnknownTag, rm.Tag(from.RegisterTag("e")))
+
+	_, err = registry.Remap(from, to, registry.FailMissing)
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	_, err = registry.Remap(from, to.Namespace("http"), registry.RegisterMissing)
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.Equal(t, 2, to.GetLen())
+
+	rm, err = registry.Remap(from.Namespace("http"), to.Namespace("http"), registry.RegisterMissing)
+	assert.NoError(t, err)
+	assert.Equal(t, to.GetTag("http.d"), rm.Tag(tags[3]))
+	rm, err = registry.Remap(from, to, registry.RegisterMissing)
+	assert.NoError(t, err)
+	assert.Equal(t, []tagmap.TagName{"c", "a", "http.d", "b", "e"}, to.Names())
+	assert.Equal(t, to.GetTag("b"), rm.Tag(tags[1]))
+
+	from.Unregister("a")
+	assert.Equal(t, tagmap.UnknownTag, rm.Tag(from.RegisterTag("f")))
+	to.Seal()
+	_, err = registry.Remap(from, to, registry.RegisterMissing)
+	assert.ErrorIs(t, err, tagmap.ErrSealed)
+}
+
+func TestMeta(t *testing.T) {
+	r := registry.New()
+	labels := map[string]string{"team": "core"}
+	latency := r.RegisterTagWithMeta("latency", registry.Meta{Description: "request latency", Unit: "ms", Default: 0.5, Labels: labels})
+	old := r.Namespace("v1").RegisterTagWithMeta("latency", registry.Meta{Deprecated: true})
+	plain := r.RegisterTag("plain")
+	labels["team"] = "other"
+
+	meta, ok := r.GetMeta(latency)
+	assert.True(t, ok)
+	assert.Equal(t, registry.Meta{Description: "request latency", Unit: "ms", Default: 0.5, Labels: map[string]string{"team": "core"}}, meta)
+	_, ok = r.GetMeta(plain)
+	assert.False(t, ok)
+	_, ok = r.Namespace("v2").GetMeta(old)
+	assert.False(t, ok)
+	assert.Equal(t, map[tagmap.Tag]any{latency: 0.5}, r.Defaults())
+	_, err := r.TryRegisterTagWithMeta("plain", registry.Meta{})
+	assert.ErrorIs(t, err, tagmap.ErrDuplicateTag)
+
+	var deprecated []tagmap.TagName
+	r.OnDeprecated(func(name tagmap.TagName) {
+		deprecated = append(deprecated, name)
+	})
+	r.GetTag("latency")
+	r.LookupTag("v1.latency")
+	r.Namespace("v1").GetTag("v1.latency")
+	assert.Equal(t, []tagmap.TagName{"v1.latency", "v1.latency"}, deprecated)
+
+	r.Unregister("v1.latency")
+	reused := r.RegisterTag("v1.latency")
+	assert.Equal(t, old.Index(), reused.Index())
+	_, ok = r.GetMeta(reused)
+	assert.False(t, ok)
+	r.GetTag("v1.latency")
+	assert.Len(t, deprecated, 2)
+}
+
+func TestDefaultsOf(t *testing.T) {
+	r := registry.New()
+	timeout := r.RegisterTagWithMeta("timeout", registry.Meta{Unit: "ns", Default: 500})
+	r.RegisterTag("plain")
+
+	durations, err := registry.DefaultsOf[time.Duration](r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.Tag]time.Duration{timeout: 500}, durations)
+	int64s, err := registry.DefaultsOf[int64](r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.Tag]int64{timeout: 500}, int64s)
+	floats, err := registry.DefaultsOf[float64](r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.Tag]float64{timeout: 500}, floats)
+	anys, err := registry.DefaultsOf[any](r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.Tag]any{timeout: 500}, anys)
+
+	_, err = registry.DefaultsOf[int8](r)
+	assert.ErrorIs(t, err, tagmap.ErrBadDefault, "overflow")
+	_, err = registry.DefaultsOf[string](r)
+	assert.ErrorIs(t, err, tagmap.ErrBadDefault)
+
+	r.RegisterTagWithMeta("ratio", registry.Meta{Default: 0.5})
+	_, err = registry.DefaultsOf[int](r)
+	assert.ErrorIs(t, err, tagmap.ErrBadDefault, "fraction")
+	r.Unregister("ratio")
+	r.RegisterTagWithMeta("offset", registry.Meta{Default: -1})
+	_, err = registry.DefaultsOf[uint64](r)
+	assert.ErrorIs(t, err, tagmap.ErrBadDefault, "sign")
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// MissingPolicy tells Remap what to do with tags whose names are not registered in the target registry
+type MissingPolicy int
+
+const (
+	// DropMissing leaves such tags out, they translate to tagmap.UnknownTag
+	DropMissing MissingPolicy = iota
+	// RegisterMissing registers missing names in the target registry
+	RegisterMissing
+	// FailMissing makes Remap return tagmap.ErrUnknownTag
+	FailMissing
+)
+
+// Remapping translates tags of one registry to tags of another one with the same names.
+// It is computed once by Remap, tags registered after that translate to tagmap.UnknownTag.
+type Remapping struct {
+	from *TagRegistry
+	to   *TagRegistry
+	// src holds source tags by index (see IndexOf), so that tags unregistered since are told apart
+	src []tagmap.Tag
+	dst []tagmap.Tag
+}
+
+// Remap builds translation of all tags of from to tags of to, matching them by full name.
+// Names missing in to are handled according to missing policy, missing names are registered all at once.
+// Names outside of namespace to can not be registered there, RegisterMissing fails for them as FailMissing does.
+// It returns tagmap.ErrUnknownTag if a name is missing and can not be registered
+// and errors of registration, e.g. tagmap.ErrSealed, nothing is registered then.
+func Remap(from, to *TagRegistry, missing MissingPolicy) (*Remapping, error) {
+	n := from.GetLen()
+	m := &Remapping{
+		from: from,
+		to:   to,
+		src:  make([]tagmap.Tag, n),
+		dst:  make([]tagmap.Tag, n),
+	}
+	var absent []int
+	for idx := 0; idx < n; idx++ {
+		m.src[idx], m.dst[idx] = from.TagAt(idx), tagmap.UnknownTag
+		if m.src[idx] == tagmap.UnknownTag {
+			continue
+		}
+		name := from.GetName(m.src[idx])
+		if tag := to.GetTag(name); tag != tagmap.UnknownTag {
+			m.dst[idx] = tag
+			continue
+		}
+		switch {
+		case missing == DropMissing:
+			// tag stays unknown
+		case missing == RegisterMissing && strings.HasPrefix(string(name), string(to.Prefix())):
+			absent = append(absent, idx)
+		default:
+			return nil, fmt.Errorf("%w: %s is missing in target registry", tagmap.ErrUnknownTag, name)
+		}
+	}
+	if len(absent) == 0 {
+		return m, nil
+	}
+	root := to
+	if to.ns != nil {
+		root = to.ns.root
+	}
+	names := make([]tagmap.TagName, len(absent))
+	for i, idx := range absent {
+		names[i] = from.GetName(m.src[idx])
+	}
+	tags, err := root.tryRegisterOrReuseTags(names)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range absent {
+		m.dst[idx] = tags[i]
+	}
+	return m, nil
+}
+
+// From returns registry tags are translated from
+func (m *Remapping) From() *TagRegistry {
+	return m.from
+}
+
+// To returns registry tags are translated to
+func (m *Remapping) To() *TagRegistry {
+	return m.to
+}
+
+// Tag translates tag of the source registry to tag of the target one,
+// it returns tagmap.UnknownTag for dropped tags and tags that are not known to the remapping
+func (m *Remapping) Tag(tag tagmap.Tag) tagmap.Tag {
+	idx := m.from.IndexOf(tag)
+	if uint(idx) >= uint(len(m.src)) || m.src[idx] != tag {
+		return tagmap.UnknownTag
+	}
+	return m.dst[idx]
+}
@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/go-auxiliaries/tagmap"
@@ -371,3 +372,166 @@ func fillRegistryTags(nIterates int, r *registry.TagRegistry) {
 		r.RegisterOrReuseTag(tagmap.TagName(strconv.Itoa(n)))
 	}
 }
+
+// boxedInt has the same size as int, but is not a scalar, so it is stored boxed
+type boxedInt struct {
+	v int
+}
+
+func Benchmark_Allocs(b *testing.B) {
+	const nUniqueKeys = 100
+	r := registry.New()
+	fillRegistryTags(nUniqueKeys, r)
+	pointers := make([]*int, nUniqueKeys)
+	for n := range pointers {
+		pointers[n] = new(int)
+	}
+	b.Run("Sync_Set", func(b *testing.B) {
+		testMap := sync.Map{}
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.Store(n%nUniqueKeys, n)
+		}
+	})
+	b.Run("Stags_SetByTag_Boxed", func(b *testing.B) {
+		testMap := stags.New[boxedInt](r)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.SetByTag(tagmap.Tag(n%nUniqueKeys), boxedInt{v: n})
+		}
+	})
+	b.Run("Stags_SetByTag_Word", func(b *testing.B) {
+		testMap := stags.New[int](r)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.SetByTag(tagmap.Tag(n%nUniqueKeys), n)
+		}
+	})
+	b.Run("Stags_SetByTag_Pointer", func(b *testing.B) {
+		testMap := stags.New[*int](r)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.SetByTag(tagmap.Tag(n%nUniqueKeys), pointers[n%nUniqueKeys])
+		}
+	})
+	b.Run("Stags_SwapByTag_Word", func(b *testing.B) {
+		testMap := stags.New[int](r)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.SwapByTag(tagmap.Tag(n%nUniqueKeys), n)
+		}
+	})
+	b.Run("Stags_GetByTagOrSet_Word", func(b *testing.B) {
+		testMap := stags.New[int](r)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			testMap.GetByTagOrSet(tagmap.Tag(n%nUniqueKeys), n)
+		}
+	})
+}
+
+// Benchmark_Layout has every goroutine write its own tag, tags are adjacent, so with the default layout
+// goroutines contend for the same cache lines although they never touch the same tag
+func Benchmark_Layout(b *testing.B) {
+	nTags := runtime.GOMAXPROCS(0)
+	r := registry.New()
+	fillRegistryTags(nTags, r)
+	layouts := []struct {
+		name string
+		opts []stags.Option
+	}{
+		{name: "Default"},
+		{name: "Padded", opts: []stags.Option{stags.Padded()}},
+	}
+	for _, layout := range layouts {
+		b.Run("Stags_SetByTag_Word_"+layout.name, func(b *testing.B) {
+			testMap := stags.New[int](r, layout.opts...)
+			next := atomic.Int64{}
+			b.RunParallel(func(pb *testing.PB) {
+				tag := tagmap.Tag(next.Add(1) % int64(nTags))
+				for n := 0; pb.Next(); n++ {
+					testMap.SetByTag(tag, n)
+				}
+			})
+		})
+		b.Run("Stags_SetByTag_Boxed_"+layout.name, func(b *testing.B) {
+			testMap := stags.New[boxedInt](r, layout.opts...)
+			next := atomic.Int64{}
+			b.RunParallel(func(pb *testing.PB) {
+				tag := tagmap.Tag(next.Add(1) % int64(nTags))
+				for n := 0; pb.Next(); n++ {
+					testMap.SetByTag(tag, boxedInt{v: n})
+				}
+			})
+		})
+		b.Run("Stags_MixedByTag_Word_"+layout.name, func(b *testing.B) {
+			testMap := stags.New[int](r, layout.opts...)
+			next := atomic.Int64{}
+			b.RunParallel(func(pb *testing.PB) {
+				tag := tagmap.Tag(next.Add(1) % int64(nTags))
+				for n := 0; pb.Next(); n++ {
+					if n%4 == 0 {
+						testMap.SetByTag(tag, n)
+					} else {
+						testMap.GetByTag(tag)
+					}
+				}
+			})
+		})
+	}
+}
+
+// Benchmark_Counters has all goroutines increment the same counter
+func Benchmark_Counters(b *testing.B) {
+	r := registry.New()
+	fillRegistryTags(1, r)
+	tag := tagmap.Tag(0)
+	b.Run("Atomic_Inc", func(b *testing.B) {
+		var counter atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				counter.Add(1)
+			}
+		})
+	})
+	b.Run("Counters_IncByTag", func(b *testing.B) {
+		counters := stags.NewCounters[int64](r)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				counters.IncByTag(tag)
+			}
+		})
+	})
+	b.Run("ShardedCounters_IncByTag", func(b *testing.B) {
+		counters := stags.NewShardedCounters[int64](r, 0)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				counters.IncByTag(tag)
+			}
+		})
+	})
+	b.Run("Counters_Mixed", func(b *testing.B) {
+		counters := stags.NewCounters[int64](r)
+		b.RunParallel(func(pb *testing.PB) {
+			for n := 0; pb.Next(); n++ {
+				if n%100 == 0 {
+					counters.GetByTag(tag)
+				} else {
+					counters.IncByTag(tag)
+				}
+			}
+		})
+	})
+	b.Run("ShardedCounters_Mixed", func(b *testing.B) {
+		counters := stags.NewShardedCounters[int64](r, 0)
+		b.RunParallel(func(pb *testing.PB) {
+			for n := 0; pb.Next(); n++ {
+				if n%100 == 0 {
+					counters.GetByTag(tag)
+				} else {
+					counters.IncByTag(tag)
+				}
+			}
+		})
+	})
+}
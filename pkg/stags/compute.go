@@ -0,0 +1,69 @@
+package stags
+
+import (
+	"sync"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// call is a compute function in flight, waiters block on done and then share val and err
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+type calls[V any] struct {
+	mu       sync.Mutex
+	inFlight map[tagmap.Tag]*call[V]
+}
+
+// GetOrComputeByTag returns tag value if it is set, otherwise it sets the value returned by fn and returns it.
+// fn runs at most once per tag at a time, concurrent callers wait for it and share its result.
+// Errors are not stored, the next call runs fn again.
+// If fn panics, the panic is propagated to its caller, while waiters get tagmap.ErrComputePanicked.
+// !! It will fail if tag is unknown !!
+func (m *SafeTagMap[V]) GetOrComputeByTag(tag tagmap.Tag, fn func() (V, error)) (V, error) {
+	if val, ok := m.load(tag); ok {
+		return val, nil
+	}
+	m.checkTag(tag)
+	m.calls.mu.Lock()
+	// value could be stored by a call that has just finished
+	if val, ok := m.load(tag); ok {
+		m.calls.mu.Unlock()
+		return val, nil
+	}
+	if c, ok := m.calls.inFlight[tag]; ok {
+		m.calls.mu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &call[V]{done: make(chan struct{}), err: tagmap.ErrComputePanicked}
+	if m.calls.inFlight == nil {
+		m.calls.inFlight = make(map[tagmap.Tag]*call[V])
+	}
+	m.calls.inFlight[tag] = c
+	m.calls.mu.Unlock()
+
+	defer func() {
+		m.calls.mu.Lock()
+		delete(m.calls.inFlight, tag)
+		m.calls.mu.Unlock()
+		close(c.done)
+	}()
+	val, err := fn()
+	if err == nil {
+		// value set meanwhile by other means wins, same as for GetByTagOrSet
+		val, _ = m.GetByTagOrSet(tag, val)
+	}
+	c.val, c.err = val, err
+	return val, err
+}
+
+// GetOrComputeByName does the same as GetOrComputeByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) GetOrComputeByName(name tagmap.TagName, fn func() (V, error)) (V, error) {
+	return m.GetOrComputeByTag(m.getTag(name), fn)
+}
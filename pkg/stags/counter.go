@@ -0,0 +1,207 @@
+package stags
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// Counter is a type CounterMap can count in
+type Counter interface {
+	~int64 | ~uint64
+}
+
+// CounterMap is a thread-safe map of counters indexed by tags.
+// Counters are stored inline and updated with atomic operations, so updates never allocate nor lock,
+// counter that was never touched is zero.
+// Same as values of SafeTagMap, counters belong to a generation of the tag (see tagmap.Tag.Generation):
+// reads via an unregistered tag return zero, updates via it fail with tagmap.ErrStaleTag,
+// and tag that reuses the index starts from zero. Only an update that was already past the check
+// when its tag got unregistered may still be counted to the tag that reused the index.
+type CounterMap[N Counter] struct {
+	counters *table[counterSlot]
+	counterSnapshots[N]
+}
+
+// counterSlot is a counter along with generation of the tag it belongs to
+type counterSlot struct {
+	val atomic.Uint64
+	// gen holds generation in the low 32 bits, genClaiming is set while the counter is reset for a newer generation
+	gen atomic.Uint64
+}
+
+const genClaiming = 1 << 32
+
+// claim returns counter of the tag for updating, counter left by an older generation of the tag is reset first
+// !! It will fail if the counter belongs to a newer generation, so the tag is stale !!
+func (c *counterSlot) claim(tag tagmap.Tag) *atomic.Uint64 {
+	gen := uint64(tag.Generation())
+	for spins := 0; ; spins++ {
+		switch cur := c.gen.Load(); {
+		case cur == gen:
+			return &c.val
+		case cur&genClaiming != 0:
+			backoff(spins)
+		case cur > gen:
+			panic(fmt.Errorf("%w: %d", tagmap.ErrStaleTag, tag))
+		case c.gen.CompareAndSwap(cur, gen|genClaiming):
+			c.val.Store(0)
+			c.gen.Store(gen)
+			return &c.val
+		}
+	}
+}
+
+// owned returns counter of the tag for reading, it returns nil if counter belongs to another generation
+func (c *counterSlot) owned(tag tagmap.Tag) *atomic.Uint64 {
+	if c.gen.Load() != uint64(tag.Generation()) {
+		return nil
+	}
+	return &c.val
+}
+
+// NewCounters creates counters for all tags of the registry,
+// Consistent and WatchBuffer options do not apply to counters
+// !! It will fail if RequireSealed is given and registry is not sealed !!
+func NewCounters[N Counter](r *registry.TagRegistry, opts ...Option) *CounterMap[N] {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &CounterMap[N]{counters: newTable[counterSlot](r.GetLen(), o.padded)}
+	m.counterSnapshots = counterSnapshots[N]{registryView: newRegistryView(r, o.requireSealed), forEach: m.forEach}
+	return m
+}
+
+// counter returns counter for writing, growing the map if the tag was registered after it was created
+// !! It will fail if tag is unknown or stale !!
+func (m *CounterMap[N]) counter(tag tagmap.Tag) *atomic.Uint64 {
+	idx := m.registry.IndexOf(tag)
+	if !m.counters.covers(idx) {
+		checkTag(m.registry, tag)
+	}
+	slot := m.counters.getOrGrow(idx)
+	if !m.live(tag) {
+		staleTag(tag, true)
+	}
+	return slot.claim(tag)
+}
+
+// load returns counter for reading, it returns nil if the tag has no counter or is stale
+func (m *CounterMap[N]) load(tag tagmap.Tag) *atomic.Uint64 {
+	slot := m.counters.get(m.registry.IndexOf(tag))
+	if slot == nil || !m.live(tag) {
+		return nil
+	}
+	return slot.owned(tag)
+}
+
+func (m *CounterMap[N]) GetByTag(tag tagmap.Tag) N {
+	counter := m.load(tag)
+	if counter == nil {
+		return 0
+	}
+	return N(counter.Load())
+}
+
+// GetByName gets counter by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) GetByName(name tagmap.TagName) N {
+	return m.GetByTag(m.getTag(name))
+}
+
+// AddByTag adds delta to counter and returns the new value
+// !! It will fail if tag is unknown !!
+func (m *CounterMap[N]) AddByTag(tag tagmap.Tag, delta N) N {
+	return N(m.counter(tag).Add(uint64(delta)))
+}
+
+// AddByName does the same as AddByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) AddByName(name tagmap.TagName, delta N) N {
+	return m.AddByTag(m.getTag(name), delta)
+}
+
+// IncByTag adds one to counter and returns the new value
+// !! It will fail if tag is unknown !!
+func (m *CounterMap[N]) IncByTag(tag tagmap.Tag) N {
+	return m.AddByTag(tag, 1)
+}
+
+// IncByName does the same as IncByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) IncByName(name tagmap.TagName) N {
+	return m.AddByTag(m.getTag(name), 1)
+}
+
+// SwapByTag sets counter and returns the previous value
+// !! It will fail if tag is unknown !!
+func (m *CounterMap[N]) SwapByTag(tag tagmap.Tag, val N) N {
+	return N(m.counter(tag).Swap(uint64(val)))
+}
+
+// SwapByName does the same as SwapByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) SwapByName(name tagmap.TagName, val N) N {
+	return m.SwapByTag(m.getTag(name), val)
+}
+
+// CompareAndSwapByTag sets counter to new if it is equal to old
+// !! It will fail if tag is unknown !!
+func (m *CounterMap[N]) CompareAndSwapByTag(tag tagmap.Tag, old, new N) bool {
+	return m.counter(tag).CompareAndSwap(uint64(old), uint64(new))
+}
+
+// CompareAndSwapByName does the same as CompareAndSwapByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) CompareAndSwapByName(name tagmap.TagName, old, new N) bool {
+	return m.CompareAndSwapByTag(m.getTag(name), old, new)
+}
+
+// ResetByTag sets counter to zero and returns the previous value
+func (m *CounterMap[N]) ResetByTag(tag tagmap.Tag) N {
+	counter := m.load(tag)
+	if counter == nil {
+		return 0
+	}
+	return N(counter.Swap(0))
+}
+
+// ResetByName does the same as ResetByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *CounterMap[N]) ResetByName(name tagmap.TagName) N {
+	return m.ResetByTag(m.getTag(name))
+}
+
+// forEach calls fn for every counter that is not zero, if reset is true counters are reset to zero on the way,
+// every counter is read independently, so the result is not a point-in-time view of all counters.
+// Counters of unregistered tags are skipped.
+func (m *CounterMap[N]) forEach(reset bool, fn func(tag tagmap.Tag, val N)) {
+	m.counters.forEach(func(idx int, slot *counterSlot) {
+		tag := m.registry.TagAt(idx)
+		if tag == tagmap.UnknownTag {
+			return
+		}
+		counter := slot.owned(tag)
+		if counter == nil {
+			return
+		}
+		var val uint64
+		if reset {
+			val = counter.Swap(0)
+		} else {
+			val = counter.Load()
+		}
+		if val != 0 {
+			fn(tag, N(val))
+		}
+	})
+}
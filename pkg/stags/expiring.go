@@ -0,0 +1,286 @@
+package stags
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// epoch is the origin of deadlines, time.Since relies on monotonic clock, so deadlines are not affected by wall clock changes
+var epoch = time.Now()
+
+func monotonic() int64 {
+	return int64(time.Since(epoch))
+}
+
+type expiring[V any] struct {
+	val V
+	// deadline is measured from epoch, zero means that value never expires
+	deadline int64
+}
+
+func (e *expiring[V]) expired(now int64) bool {
+	return e.deadline != 0 && now >= e.deadline
+}
+
+// ExpiringMap is a SafeTagMap whose values expire after a while.
+// Expired values are treated as absent and are evicted lazily, when they are read,
+// or in bulk via Evict, which can be run periodically via StartJanitor.
+// Every write allocates, since expiration time is stored along with the value.
+type ExpiringMap[V any] struct {
+	values  *SafeTagMap[expiring[V]]
+	ttl     time.Duration
+	onEvict atomic.Pointer[func(tag tagmap.Tag, val V)]
+}
+
+var _ tagmap.ReadOnlyMap[int] = (*ExpiringMap[int])(nil)
+
+// NewExpiring creates map for all tags of the registry, values set without explicit TTL expire after ttl,
+// zero ttl means that they never expire.
+// Options are the same as for New.
+// !! It will fail if RequireSealed is given and registry is not sealed !!
+func NewExpiring[V any](r *registry.TagRegistry, ttl time.Duration, opts ...Option) *ExpiringMap[V] {
+	return &ExpiringMap[V]{
+		values: New[expiring[V]](r, opts...),
+		ttl:    ttl,
+	}
+}
+
+// OnEvict sets fn to be called for every value that is evicted because it has expired,
+// it is called by the goroutine that noticed expiration, values that are deleted or overwritten are not reported
+func (m *ExpiringMap[V]) OnEvict(fn func(tag tagmap.Tag, val V)) {
+	m.onEvict.Store(&fn)
+}
+
+func (m *ExpiringMap[V]) entry(val V, ttl time.Duration) *expiring[V] {
+	e := &expiring[V]{val: val}
+	if ttl > 0 {
+		e.deadline = monotonic() + int64(ttl)
+	}
+	return e
+}
+
+// evict deletes expired entry unless it was replaced meanwhile
+func (m *ExpiringMap[V]) evict(tag tagmap.Tag, e *expiring[V]) bool {
+	if !m.values.deleteIfSame(tag, e) {
+		return false
+	}
+	m.evicted(tag, e)
+	return true
+}
+
+func (m *ExpiringMap[V]) evicted(tag tagmap.Tag, e *expiring[V]) {
+	if fn := m.onEvict.Load(); fn != nil {
+		(*fn)(tag, e.val)
+	}
+}
+
+// deleteIfSame deletes tag value if it is still the one ptr points to, values must be boxed
+func (m *SafeTagMap[V]) deleteIfSame(tag tagmap.Tag, ptr *V) bool {
+	m.gate.enter()
+	defer m.gate.leave()
+	_, deleted := m.updatePtr(tag, false, func(old *V, _ uint64) (*V, bool) {
+		return nil, old == ptr
+	})
+	return deleted
+}
+
+func (m *ExpiringMap[V]) IsTagName(name tagmap.TagName) bool {
+	return m.values.IsTagName(name)
+}
+
+func (m *ExpiringMap[V]) TagByName(name tagmap.TagName) tagmap.Tag {
+	return m.values.TagByName(name)
+}
+
+// Load gets tag value and reports whether it is set and has not expired yet
+func (m *ExpiringMap[V]) Load(tag tagmap.Tag) (V, bool) {
+	e := m.values.loadPtr(tag)
+	if e == nil {
+		return *new(V), false
+	}
+	if e.expired(monotonic()) {
+		m.evict(tag, e)
+		return *new(V), false
+	}
+	return e.val, true
+}
+
+func (m *ExpiringMap[V]) Has(tag tagmap.Tag) bool {
+	_, ok := m.Load(tag)
+	return ok
+}
+
+func (m *ExpiringMap[V]) GetByTag(tag tagmap.Tag) V {
+	val, _ := m.Load(tag)
+	return val
+}
+
+// GetByName gets tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName or use LoadByName
+func (m *ExpiringMap[V]) GetByName(name tagmap.TagName) V {
+	return m.GetByTag(m.values.getTag(name))
+}
+
+// LoadByName gets tag value by tag name, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *ExpiringMap[V]) LoadByName(name tagmap.TagName) (V, error) {
+	tag, err := m.values.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
+	}
+	return m.GetByTag(tag), nil
+}
+
+// SetByTag sets tag value that expires after the map TTL
+func (m *ExpiringMap[V]) SetByTag(tag tagmap.Tag, val V) {
+	m.SetByTagWithTTL(tag, val, m.ttl)
+}
+
+// SetByTagWithTTL sets tag value that expires after ttl, zero ttl means that it never expires
+func (m *ExpiringMap[V]) SetByTagWithTTL(tag tagmap.Tag, val V, ttl time.Duration) {
+	m.values.setPtr(tag, m.entry(val, ttl))
+}
+
+// SetByName sets tag value by tag name, it expires after the map TTL
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ExpiringMap[V]) SetByName(name tagmap.TagName, val V) {
+	m.SetByTagWithTTL(m.values.getTag(name), val, m.ttl)
+}
+
+// SetByNameWithTTL does the same as SetByTagWithTTL
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ExpiringMap[V]) SetByNameWithTTL(name tagmap.TagName, val V, ttl time.Duration) {
+	m.SetByTagWithTTL(m.values.getTag(name), val, ttl)
+}
+
+// GetByTagOrSet returns tag value if it is set and has not expired yet,
+// otherwise it sets val that expires after the map TTL and returns it,
+// second result reports whether value was loaded
+func (m *ExpiringMap[V]) GetByTagOrSet(tag tagmap.Tag, val V) (V, bool) {
+	m.values.gate.enter()
+	defer m.values.gate.leave()
+	fresh := m.entry(val, m.ttl)
+	e, stored := m.values.updatePtr(tag, true, func(e *expiring[V], _ uint64) (*expiring[V], bool) {
+		return fresh, e == nil || e.expired(monotonic())
+	})
+	if !stored {
+		return e.val, true
+	}
+	if e != nil {
+		m.evicted(tag, e)
+	}
+	return val, false
+}
+
+// GetByNameOrSet does the same as GetByTagOrSet
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ExpiringMap[V]) GetByNameOrSet(name tagmap.TagName, val V) (V, bool) {
+	return m.GetByTagOrSet(m.values.getTag(name), val)
+}
+
+// GetByTagAndDelete deletes tag value and returns the previous one unless it has expired
+func (m *ExpiringMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
+	m.values.gate.enter()
+	defer m.values.gate.leave()
+	e, existed := m.values.swap(tag, expiring[V]{}, false)
+	if !existed || e.expired(monotonic()) {
+		return *new(V)
+	}
+	return e.val
+}
+
+func (m *ExpiringMap[V]) DeleteByTag(tag tagmap.Tag) {
+	m.values.DeleteByTag(tag)
+}
+
+// DeleteByName deletes tag value by tag name
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ExpiringMap[V]) DeleteByName(name tagmap.TagName) {
+	m.values.DeleteByTag(m.values.getTag(name))
+}
+
+// Evict deletes all expired values and returns number of them
+func (m *ExpiringMap[V]) Evict() int {
+	now := monotonic()
+	n := 0
+	m.values.ptrs.forEach(func(idx int, slot *ptrSlot[expiring[V]]) {
+		e, gen, seq := slot.read()
+		if tag, ok := m.values.slotTag(idx, gen, seq); ok && e.expired(now) && m.evict(tag, e) {
+			n++
+		}
+	})
+	return n
+}
+
+// StartJanitor runs Evict every interval in background until stop is called
+func (m *ExpiringMap[V]) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Evict()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// forEach calls fn for every tag that has value that has not expired yet
+func (m *ExpiringMap[V]) forEach(fn func(tag tagmap.Tag, val V)) {
+	now := monotonic()
+	m.values.forEach(func(tag tagmap.Tag, e expiring[V]) {
+		if !e.expired(now) {
+			fn(tag, e.val)
+		}
+	})
+}
+
+func (m *ExpiringMap[V]) ValuesByTag() map[tagmap.Tag]V {
+	out := make(map[tagmap.Tag]V)
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[tag] = val
+	})
+	return out
+}
+
+func (m *ExpiringMap[V]) ValuesByName() map[tagmap.TagName]V {
+	out := make(map[tagmap.TagName]V)
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[m.values.name(tag)] = val
+	})
+	return out
+}
+
+func (m *ExpiringMap[V]) GetValuesByName(names ...tagmap.TagName) tagmap.List[V] {
+	out := make(tagmap.List[V], len(names))
+	for idx, name := range names {
+		out[idx] = m.GetByName(name)
+	}
+	return out
+}
+
+func (m *ExpiringMap[V]) GetValuesByTag(tags ...tagmap.Tag) tagmap.List[V] {
+	out := make(tagmap.List[V], len(tags))
+	for idx, tag := range tags {
+		out[idx] = m.GetByTag(tag)
+	}
+	return out
+}
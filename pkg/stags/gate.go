@@ -0,0 +1,40 @@
+package stags
+
+import "sync"
+
+// gate coordinates writes with operations that need a consistent view of all tags.
+// Plain writes pass the gate concurrently with each other, while Snapshot and Update commits hold it exclusively,
+// so they observe either all or none of the writes in flight.
+// Every write pays for two atomic read-modify-writes on the reader count of the mutex, which all writers share,
+// so writers contend on one cache line even if they write different tags.
+// Reads never pass the gate. Nil gate is open, see Consistent option.
+type gate struct {
+	mu sync.RWMutex
+}
+
+func newGate(enabled bool) *gate {
+	if !enabled {
+		return nil
+	}
+	return &gate{}
+}
+
+func (g *gate) enter() {
+	if g != nil {
+		g.mu.RLock()
+	}
+}
+
+func (g *gate) leave() {
+	if g != nil {
+		g.mu.RUnlock()
+	}
+}
+
+func (g *gate) lock() {
+	g.mu.Lock()
+}
+
+func (g *gate) unlock() {
+	g.mu.Unlock()
+}
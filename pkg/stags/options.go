@@ -0,0 +1,57 @@
+package stags
+
+type options struct {
+	requireSealed bool
+	consistent    bool
+	watchBuffer   int
+	padded        bool
+	defaults      bool
+}
+
+type Option func(*options)
+
+// RequireSealed makes New fail if registry is not sealed
+func RequireSealed() Option {
+	return func(o *options) {
+		o.requireSealed = true
+	}
+}
+
+// Consistent enables SafeTagMap.Snapshot and SafeTagMap.Update.
+// Every write then passes a shared gate: RWMutex.RLock and RUnlock, two atomic read-modify-writes
+// on the same reader count for all writers, and writes wait while a Snapshot or Update commit holds the gate.
+// Reads are not affected. Since all writers contend on the gate, it cancels what Padded gains,
+// the two options do not combine usefully.
+func Consistent() Option {
+	return func(o *options) {
+		o.consistent = true
+	}
+}
+
+// WatchBuffer sets buffer size of channels returned by SafeTagMap.Watch, changes that do not fit are dropped.
+// Negative n is treated as 0, so changes are delivered only to watchers that are ready to receive them.
+func WatchBuffer(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.watchBuffer = n
+	}
+}
+
+// Padded places every tag on its own cache line, so goroutines writing different tags do not slow each other down
+// by invalidating the same cache line (false sharing). It makes the map several times bigger,
+// so it pays off for small sets of tags that are written heavily from many goroutines, unless Consistent is used too.
+func Padded() Option {
+	return func(o *options) {
+		o.padded = true
+	}
+}
+
+// Defaults makes New set every tag to its default value, see registry.Meta.Default and registry.DefaultsOf
+// !! New will fail if default of any tag does not fit the map value type !!
+func Defaults() Option {
+	return func(o *options) {
+		o.defaults = true
+	}
+}
@@ -0,0 +1,161 @@
+package stags
+
+import (
+	"math/bits"
+	"runtime"
+	"unsafe"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// ShardedCounterMap is a CounterMap for counters that are updated from many goroutines at once.
+// Every counter is striped over several cells, each on its own cache line, a goroutine updates one of them
+// and reads sum them all. Updates of the same counter then rarely contend, while reads get slower.
+// Unlike CounterMap, updates do not return the new value, since computing it would need all cells.
+// Same as CounterMap, counters belong to a generation of the tag, every cell keeps the generation it counts for.
+type ShardedCounterMap[N Counter] struct {
+	// cells of a tag are adjacent: cell of shard s of tag t is t<<shift + s
+	cells *table[counterSlot]
+	shift int
+	counterSnapshots[N]
+}
+
+// NewShardedCounters creates counters for all tags of the registry, each striped over shards cells.
+// Zero shards means GOMAXPROCS, number of shards is rounded up to a power of two.
+// Consistent, WatchBuffer and Padded options do not apply, cells are always padded.
+// !! It will fail if RequireSealed is given and registry is not sealed !!
+func NewShardedCounters[N Counter](r *registry.TagRegistry, shards int, opts ...Option) *ShardedCounterMap[N] {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	shift := bits.Len(uint(shards - 1))
+	m := &ShardedCounterMap[N]{cells: newTable[counterSlot](r.GetLen()<<shift, true), shift: shift}
+	m.counterSnapshots = counterSnapshots[N]{registryView: newRegistryView(r, o.requireSealed), forEach: m.forEach}
+	return m
+}
+
+// shard picks a shard for the calling goroutine without any shared state by hashing the address of a local variable.
+// Goroutines run on distinct stacks, so shards are per goroutine, not per CPU: goroutines running on different
+// CPUs may hash to the same shard, and a goroutine may move to another shard when its stack grows and is copied.
+// With many more goroutines than shards contention is spread evenly, which is the case sharding is for.
+func (m *ShardedCounterMap[N]) shard() int {
+	if m.shift == 0 {
+		return 0
+	}
+	var local byte
+	h := uint64(uintptr(unsafe.Pointer(&local))) * 0x9E3779B97F4A7C15
+	return int(h >> (64 - m.shift))
+}
+
+// AddByTag adds delta to counter
+// !! It will fail if tag is unknown or stale !!
+func (m *ShardedCounterMap[N]) AddByTag(tag tagmap.Tag, delta N) {
+	idx := m.registry.IndexOf(tag)<<m.shift + m.shard()
+	if !m.cells.covers(idx) {
+		checkTag(m.registry, tag)
+	}
+	cell := m.cells.getOrGrow(idx)
+	if !m.live(tag) {
+		staleTag(tag, true)
+	}
+	cell.claim(tag).Add(uint64(delta))
+}
+
+// AddByName does the same as AddByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ShardedCounterMap[N]) AddByName(name tagmap.TagName, delta N) {
+	m.AddByTag(m.getTag(name), delta)
+}
+
+// IncByTag adds one to counter
+// !! It will fail if tag is unknown !!
+func (m *ShardedCounterMap[N]) IncByTag(tag tagmap.Tag) {
+	m.AddByTag(tag, 1)
+}
+
+// IncByName does the same as IncByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ShardedCounterMap[N]) IncByName(name tagmap.TagName) {
+	m.AddByTag(m.getTag(name), 1)
+}
+
+// sum adds up all cells of the tag, resetting them if reset is true, stale tags sum up to zero
+func (m *ShardedCounterMap[N]) sum(tag tagmap.Tag, reset bool) N {
+	if !m.live(tag) {
+		return 0
+	}
+	var sum uint64
+	first := m.registry.IndexOf(tag) << m.shift
+	for idx := first; idx < first+1<<m.shift; idx++ {
+		sum += m.add(m.cells.get(idx), tag, reset)
+	}
+	return N(sum)
+}
+
+// add returns value of the cell if it belongs to the tag, resetting it if reset is true, cell may be nil
+func (m *ShardedCounterMap[N]) add(cell *counterSlot, tag tagmap.Tag, reset bool) uint64 {
+	if cell == nil {
+		return 0
+	}
+	counter := cell.owned(tag)
+	switch {
+	case counter == nil:
+		return 0
+	case reset:
+		return counter.Swap(0)
+	}
+	return counter.Load()
+}
+
+// GetByTag sums up counter, concurrent updates may or may not be counted
+func (m *ShardedCounterMap[N]) GetByTag(tag tagmap.Tag) N {
+	return m.sum(tag, false)
+}
+
+// GetByName does the same as GetByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ShardedCounterMap[N]) GetByName(name tagmap.TagName) N {
+	return m.sum(m.getTag(name), false)
+}
+
+// ResetByTag sets counter to zero and returns the previous value,
+// every update is counted exactly once, either in the result or in the counter afterwards
+func (m *ShardedCounterMap[N]) ResetByTag(tag tagmap.Tag) N {
+	return m.sum(tag, true)
+}
+
+// ResetByName does the same as ResetByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *ShardedCounterMap[N]) ResetByName(name tagmap.TagName) N {
+	return m.sum(m.getTag(name), true)
+}
+
+// forEach calls fn for every counter that is not zero, if reset is true counters are reset to zero on the way.
+// Counters of unregistered tags are skipped.
+func (m *ShardedCounterMap[N]) forEach(reset bool, fn func(tag tagmap.Tag, val N)) {
+	emit := func(tag tagmap.Tag, sum uint64) {
+		if sum != 0 && tag != tagmap.UnknownTag {
+			fn(tag, N(sum))
+		}
+	}
+	idx, tag, sum := 0, m.registry.TagAt(0), uint64(0)
+	m.cells.forEach(func(cellIdx int, cell *counterSlot) {
+		if next := cellIdx >> m.shift; next != idx {
+			emit(tag, sum)
+			idx, tag, sum = next, m.registry.TagAt(next), 0
+		}
+		if tag != tagmap.UnknownTag {
+			sum += m.add(cell, tag, reset)
+		}
+	})
+	emit(tag, sum)
+}
@@ -0,0 +1,561 @@
+package stags
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// Values are kept in one of the three ways, depending on the kind of V:
+//   - word sized scalars (ints, bools, floats) are stored inline in a wordSlot, writes never allocate
+//   - pointers are stored as is in a ptrSlot, nil pointer is stored as nilPointer, writes never allocate
+//   - everything else is boxed: ptrSlot points to a copy of the value, every write allocates
+type storage int
+
+const (
+	storageBoxed storage = iota
+	storageDirect
+	storageWord
+)
+
+func storageOf[V any]() storage {
+	switch reflect.TypeOf((*V)(nil)).Elem().Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64:
+		return storageWord
+	case reflect.Pointer, reflect.UnsafePointer:
+		return storageDirect
+	}
+	return storageBoxed
+}
+
+// nilPointer marks a slot that holds nil pointer, so it is distinguishable from an empty slot
+var nilPointer uintptr
+
+const (
+	seqLocked  = 1
+	seqPresent = 2
+	seqActive  = 4
+	seqStep    = 8
+
+	spinsBeforeYield = 4
+)
+
+// seqlock guards a slot and counts writes to it. Slot keeps two copies of its value:
+// readers read the active one, while a writer fills the spare one and then makes it active.
+// So writers of the same slot are serialized, while readers never wait for them,
+// they retry only if a write was completed while they were reading.
+type seqlock struct {
+	// bit 0 - slot is locked by a writer, bit 1 - value is present, bit 2 - index of the active copy,
+	// bits 3-63 count writes, it takes 2^61 writes to wrap them, which does not happen in practice
+	seq atomic.Uint64
+	// gens hold generation of the tag that wrote each copy, see tagmap.Tag.Generation
+	gens [2]atomic.Uint32
+}
+
+// lock acquires slot for writing and returns its sequence
+func (l *seqlock) lock() uint64 {
+	for spins := 0; ; spins++ {
+		seq := l.seq.Load()
+		if seq&seqLocked == 0 && l.seq.CompareAndSwap(seq, seq|seqLocked) {
+			return seq
+		}
+		backoff(spins)
+	}
+}
+
+// unlock releases slot without changing it
+func (l *seqlock) unlock(seq uint64) {
+	l.seq.Store(seq)
+}
+
+// gen returns generation of the tag that wrote the active copy, slot must be locked
+func (l *seqlock) gen(seq uint64) uint32 {
+	return l.gens[active(seq)].Load()
+}
+
+// prepare records tag that wrote the spare copy and returns the sequence that makes it active and counts the write
+func (l *seqlock) prepare(seq uint64, tag tagmap.Tag, present bool) uint64 {
+	l.gens[spare(seq)].Store(tag.Generation())
+	next := (seq + seqStep) ^ seqActive
+	next &^= seqPresent
+	if present {
+		next |= seqPresent
+	}
+	return next
+}
+
+func active(seq uint64) int {
+	return int(seq & seqActive >> 2)
+}
+
+func spare(seq uint64) int {
+	return active(seq) ^ 1
+}
+
+// version returns number of writes to the slot
+func version(seq uint64) uint64 {
+	return seq / seqStep
+}
+
+// versionOf returns version of the slot as seen by the tag: slot last written by an older generation of the tag
+// is at version 0 for it, so versions of a tag that reused an index start from 0 as well
+func versionOf(seq uint64, gen uint32, tag tagmap.Tag) uint64 {
+	if gen < tag.Generation() {
+		return 0
+	}
+	return version(seq)
+}
+
+// present reports whether slot holds value of the tag, gen is generation of the tag that wrote it
+func present(seq uint64, gen uint32, tag tagmap.Tag) bool {
+	return seq&seqPresent != 0 && gen == tag.Generation()
+}
+
+// owned reports whether slot holds value of the tag for writing:
+// value left by an older generation of the tag is treated as missing, it is dropped by the first write,
+// while value of a newer generation means that the tag is stale
+func owned(seq uint64, gen uint32, tag tagmap.Tag) (existed bool, stale bool) {
+	switch {
+	case gen == tag.Generation():
+		return seq&seqPresent != 0, false
+	case gen < tag.Generation():
+		return false, false
+	}
+	return false, true
+}
+
+// staleTag is called with the slot unlocked for tags that were unregistered,
+// writes via stale tags fail, while deletes do nothing
+func staleTag(tag tagmap.Tag, write bool) {
+	if write {
+		panic(fmt.Errorf("%w: %d", tagmap.ErrStaleTag, tag))
+	}
+}
+
+func backoff(spins int) {
+	if spins >= spinsBeforeYield {
+		runtime.Gosched()
+	}
+}
+
+// wordSlot keeps word sized value inline
+type wordSlot struct {
+	seqlock
+	words [2]atomic.Uint64
+}
+
+// read returns the value along with the sequence and generation it was written at
+func (s *wordSlot) read() (word uint64, gen uint32, seq uint64) {
+	for {
+		seq = s.seq.Load() &^ seqLocked
+		word, gen = s.words[active(seq)].Load(), s.gens[active(seq)].Load()
+		if s.seq.Load()&^seqLocked == seq {
+			return word, gen, seq
+		}
+	}
+}
+
+// write stores value of the tag to the locked slot and releases it, it returns the new sequence
+func (s *wordSlot) write(seq uint64, tag tagmap.Tag, word uint64, present bool) uint64 {
+	s.words[spare(seq)].Store(word)
+	seq = s.prepare(seq, tag, present)
+	s.seq.Store(seq)
+	return seq
+}
+
+// ptrSlot keeps pointer to the value, nil means that value is not set
+type ptrSlot[V any] struct {
+	seqlock
+	ptrs [2]atomic.Pointer[V]
+}
+
+// read returns the pointer along with the sequence and generation it was written at
+func (s *ptrSlot[V]) read() (ptr *V, gen uint32, seq uint64) {
+	for {
+		seq = s.seq.Load() &^ seqLocked
+		ptr, gen = s.ptrs[active(seq)].Load(), s.gens[active(seq)].Load()
+		if s.seq.Load()&^seqLocked == seq {
+			return ptr, gen, seq
+		}
+	}
+}
+
+// write does the same as wordSlot.write, once the write is visible the previous pointer is dropped,
+// so that the slot does not keep the old value alive
+func (s *ptrSlot[V]) write(seq uint64, tag tagmap.Tag, ptr *V) uint64 {
+	s.ptrs[spare(seq)].Store(ptr)
+	next := s.prepare(seq, tag, ptr != nil)
+	s.seq.Store(next | seqLocked)
+	// readers of the previous copy retry, since the sequence has changed
+	s.ptrs[active(seq)].Store(nil)
+	s.seq.Store(next)
+	return next
+}
+
+func toWord[V any](val V) uint64 {
+	switch unsafe.Sizeof(val) {
+	case 1:
+		return uint64(*(*uint8)(unsafe.Pointer(&val)))
+	case 2:
+		return uint64(*(*uint16)(unsafe.Pointer(&val)))
+	case 4:
+		return uint64(*(*uint32)(unsafe.Pointer(&val)))
+	}
+	return *(*uint64)(unsafe.Pointer(&val))
+}
+
+func fromWord[V any](word uint64) V {
+	var val V
+	switch unsafe.Sizeof(val) {
+	case 1:
+		*(*uint8)(unsafe.Pointer(&val)) = uint8(word)
+	case 2:
+		*(*uint16)(unsafe.Pointer(&val)) = uint16(word)
+	case 4:
+		*(*uint32)(unsafe.Pointer(&val)) = uint32(word)
+	default:
+		*(*uint64)(unsafe.Pointer(&val)) = word
+	}
+	return val
+}
+
+// encode turns value into what is stored in ptrSlot
+func (m *SafeTagMap[V]) encode(val V) *V {
+	if m.storage == storageBoxed {
+		return box(val)
+	}
+	ptr := *(**V)(unsafe.Pointer(&val))
+	if ptr == nil {
+		return m.nilPointer
+	}
+	return ptr
+}
+
+// box is kept apart from encode, otherwise val escapes to heap for pointers as well
+func box[V any](val V) *V {
+	return &val
+}
+
+// decode does the opposite of encode, ptr must not be nil
+func (m *SafeTagMap[V]) decode(ptr *V) V {
+	if m.storage == storageBoxed {
+		return *ptr
+	}
+	if ptr == m.nilPointer {
+		return *new(V)
+	}
+	return *(*V)(unsafe.Pointer(&ptr))
+}
+
+// mustBeComparable panics if val can not be compared, the same way comparison of interfaces does,
+// it is called before a slot is locked, so that the slot is not left locked
+func mustBeComparable[V any](val V) {
+	_ = any(val) == any(val)
+}
+
+func (m *SafeTagMap[V]) checkTag(tag tagmap.Tag) {
+	checkTag(m.registry, tag)
+}
+
+// checkTag makes sure tag is registered, it is called before growing maps
+func checkTag(r *registry.TagRegistry, tag tagmap.Tag) {
+	if !r.Has(tag) {
+		panic("there is no such tag " + strconv.Itoa(int(tag)))
+	}
+}
+
+// ptrSlot returns slot for reading, nil means that nothing was ever written to the tag
+func (m *SafeTagMap[V]) ptrSlot(tag tagmap.Tag) *ptrSlot[V] {
+	return m.ptrs.get(m.registry.IndexOf(tag))
+}
+
+// ptrSlotOrGrow returns slot for writing, growing the map if the tag was registered after it was created
+func (m *SafeTagMap[V]) ptrSlotOrGrow(tag tagmap.Tag) *ptrSlot[V] {
+	idx := m.registry.IndexOf(tag)
+	if !m.ptrs.covers(idx) {
+		m.checkTag(tag)
+	}
+	return m.ptrs.getOrGrow(idx)
+}
+
+func (m *SafeTagMap[V]) wordSlot(tag tagmap.Tag) *wordSlot {
+	return m.words.get(m.registry.IndexOf(tag))
+}
+
+func (m *SafeTagMap[V]) wordSlotOrGrow(tag tagmap.Tag) *wordSlot {
+	idx := m.registry.IndexOf(tag)
+	if !m.words.covers(idx) {
+		m.checkTag(tag)
+	}
+	return m.words.getOrGrow(idx)
+}
+
+// load returns tag value and reports whether it is set, values are not visible via unregistered tags
+func (m *SafeTagMap[V]) load(tag tagmap.Tag) (V, bool) {
+	if m.storage == storageWord {
+		slot := m.wordSlot(tag)
+		if slot == nil {
+			return *new(V), false
+		}
+		word, gen, seq := slot.read()
+		if !present(seq, gen, tag) || !m.live(tag) {
+			return *new(V), false
+		}
+		return fromWord[V](word), true
+	}
+	ptr := m.loadPtr(tag)
+	if ptr == nil {
+		return *new(V), false
+	}
+	return m.decode(ptr), true
+}
+
+func (m *SafeTagMap[V]) loadPtr(tag tagmap.Tag) *V {
+	slot := m.ptrSlot(tag)
+	if slot == nil {
+		return nil
+	}
+	ptr, gen, seq := slot.read()
+	if !present(seq, gen, tag) || !m.live(tag) {
+		return nil
+	}
+	return ptr
+}
+
+// loadVersioned does the same as load, additionally returning version of the slot
+func (m *SafeTagMap[V]) loadVersioned(tag tagmap.Tag) (V, bool, uint64) {
+	if m.storage == storageWord {
+		slot := m.wordSlot(tag)
+		if slot == nil {
+			return *new(V), false, 0
+		}
+		word, gen, seq := slot.read()
+		if !present(seq, gen, tag) || !m.live(tag) {
+			return *new(V), false, versionOf(seq, gen, tag)
+		}
+		return fromWord[V](word), true, version(seq)
+	}
+	slot := m.ptrSlot(tag)
+	if slot == nil {
+		return *new(V), false, 0
+	}
+	ptr, gen, seq := slot.read()
+	if !present(seq, gen, tag) || !m.live(tag) {
+		return *new(V), false, versionOf(seq, gen, tag)
+	}
+	return m.decode(ptr), true, version(seq)
+}
+
+// update changes tag value under the slot lock: fn gets the current value and version of the slot
+// and returns the new value, present false means deleting it, ok false leaves the slot untouched.
+// If grow is false and the slot was never allocated, fn is not called, since there is nothing to change.
+// It returns the previous value and reports whether the slot was changed.
+// !! It will fail if grow is true and tag is stale !!
+func (m *SafeTagMap[V]) update(tag tagmap.Tag, grow bool,
+	fn func(old V, existed bool, ver uint64) (val V, present bool, ok bool)) (V, bool, bool) {
+	if m.storage != storageWord {
+		var old V
+		var existed bool
+		_, changed := m.updatePtr(tag, grow, func(ptr *V, ver uint64) (*V, bool) {
+			if ptr != nil {
+				old, existed = m.decode(ptr), true
+			}
+			val, present, ok := fn(old, existed, ver)
+			if !ok || !present {
+				return nil, ok
+			}
+			return m.encode(val), true
+		})
+		return old, existed, changed
+	}
+	var slot *wordSlot
+	if grow {
+		slot = m.wordSlotOrGrow(tag)
+	} else if slot = m.wordSlot(tag); slot == nil {
+		return *new(V), false, false
+	}
+	if !m.live(tag) {
+		staleTag(tag, grow)
+		return *new(V), false, false
+	}
+	seq := slot.lock()
+	gen := slot.gen(seq)
+	existed, stale := owned(seq, gen, tag)
+	if stale {
+		slot.unlock(seq)
+		staleTag(tag, grow)
+		return *new(V), false, false
+	}
+	var old V
+	if existed {
+		old = fromWord[V](slot.words[active(seq)].Load())
+	}
+	val, present, ok := fn(old, existed, versionOf(seq, gen, tag))
+	if !ok {
+		slot.unlock(seq)
+		return old, existed, false
+	}
+	seq = slot.write(seq, tag, toWord(val), present)
+	m.notify(tag, old, existed, val, present, version(seq))
+	return old, existed, true
+}
+
+// updatePtr does the same as update, but on pointers as they are stored in ptrSlot
+func (m *SafeTagMap[V]) updatePtr(tag tagmap.Tag, grow bool, fn func(old *V, ver uint64) (*V, bool)) (*V, bool) {
+	var slot *ptrSlot[V]
+	if grow {
+		slot = m.ptrSlotOrGrow(tag)
+	} else if slot = m.ptrSlot(tag); slot == nil {
+		return nil, false
+	}
+	if !m.live(tag) {
+		staleTag(tag, grow)
+		return nil, false
+	}
+	seq := slot.lock()
+	old, stale := m.ownedPtr(slot, seq, tag)
+	if stale {
+		slot.unlock(seq)
+		staleTag(tag, grow)
+		return nil, false
+	}
+	ptr, ok := fn(old, versionOf(seq, slot.gen(seq), tag))
+	if !ok {
+		slot.unlock(seq)
+		return old, false
+	}
+	m.notifyPtr(tag, old, ptr, slot.write(seq, tag, ptr))
+	return old, true
+}
+
+// ownedPtr does the same as owned, returning pointer the slot holds for the tag
+func (m *SafeTagMap[V]) ownedPtr(slot *ptrSlot[V], seq uint64, tag tagmap.Tag) (*V, bool) {
+	existed, stale := owned(seq, slot.gen(seq), tag)
+	if !existed {
+		return nil, stale
+	}
+	return slot.ptrs[active(seq)].Load(), false
+}
+
+// swapPtr is a shortcut for updatePtr that unconditionally stores ptr, it is the hot path of SetByTag
+func (m *SafeTagMap[V]) swapPtr(tag tagmap.Tag, ptr *V) *V {
+	var slot *ptrSlot[V]
+	if ptr != nil {
+		slot = m.ptrSlotOrGrow(tag)
+	} else if slot = m.ptrSlot(tag); slot == nil {
+		return nil
+	}
+	if !m.live(tag) {
+		staleTag(tag, ptr != nil)
+		return nil
+	}
+	seq := slot.lock()
+	old, stale := m.ownedPtr(slot, seq, tag)
+	if stale || old == nil && ptr == nil {
+		slot.unlock(seq)
+		staleTag(tag, stale && ptr != nil)
+		return nil
+	}
+	m.notifyPtr(tag, old, ptr, slot.write(seq, tag, ptr))
+	return old
+}
+
+func (m *SafeTagMap[V]) notifyPtr(tag tagmap.Tag, old, ptr *V, seq uint64) {
+	if !m.watched() {
+		return
+	}
+	var oldVal, val V
+	if old != nil {
+		oldVal = m.decode(old)
+	}
+	if ptr != nil {
+		val = m.decode(ptr)
+	}
+	m.notify(tag, oldVal, old != nil, val, ptr != nil, version(seq))
+}
+
+// swap sets tag value, or deletes it if present is false, and returns the previous one
+func (m *SafeTagMap[V]) swap(tag tagmap.Tag, val V, present bool) (V, bool) {
+	if m.storage != storageWord {
+		var ptr *V
+		if present {
+			ptr = m.encode(val)
+		}
+		if old := m.swapPtr(tag, ptr); old != nil {
+			return m.decode(old), true
+		}
+		return *new(V), false
+	}
+	old, existed, _ := m.update(tag, present, func(_ V, existed bool, _ uint64) (V, bool, bool) {
+		return val, present, existed || present
+	})
+	return old, existed
+}
+
+// loadOrStore sets tag value if it is not set, it returns the actual value and reports whether it was loaded
+func (m *SafeTagMap[V]) loadOrStore(tag tagmap.Tag, val V) (V, bool) {
+	if actual, ok := m.load(tag); ok {
+		return actual, true
+	}
+	actual, loaded, _ := m.update(tag, true, func(_ V, existed bool, _ uint64) (V, bool, bool) {
+		return val, true, !existed
+	})
+	if loaded {
+		return actual, true
+	}
+	return val, false
+}
+
+// compareAndSwap sets tag value, or deletes it if present is false, if it is set and equal to old
+func (m *SafeTagMap[V]) compareAndSwap(tag tagmap.Tag, old, val V, present bool) bool {
+	mustBeComparable(old)
+	_, _, changed := m.update(tag, false, func(actual V, existed bool, _ uint64) (V, bool, bool) {
+		return val, present, existed && any(actual) == any(old)
+	})
+	return changed
+}
+
+// storeIfVersion sets tag value, or deletes it if present is false, if version of the slot is equal to expected
+func (m *SafeTagMap[V]) storeIfVersion(tag tagmap.Tag, val V, present bool, expected uint64) bool {
+	_, _, changed := m.update(tag, present, func(_ V, existed bool, ver uint64) (V, bool, bool) {
+		return val, present, ver == expected && (existed || present)
+	})
+	return changed
+}
+
+// slotTag returns tag that wrote the slot, it reports false if the slot is empty or the tag was unregistered since
+func (m *SafeTagMap[V]) slotTag(idx int, gen uint32, seq uint64) (tagmap.Tag, bool) {
+	if seq&seqPresent == 0 {
+		return tagmap.UnknownTag, false
+	}
+	tag := m.registry.TagAt(idx)
+	return tag, tag != tagmap.UnknownTag && present(seq, gen, tag)
+}
+
+// forEach calls fn for every tag that has value, values of unregistered tags are skipped
+func (m *SafeTagMap[V]) forEach(fn func(tag tagmap.Tag, val V)) {
+	if m.storage == storageWord {
+		m.words.forEach(func(idx int, slot *wordSlot) {
+			word, gen, seq := slot.read()
+			if tag, ok := m.slotTag(idx, gen, seq); ok {
+				fn(tag, fromWord[V](word))
+			}
+		})
+		return
+	}
+	m.ptrs.forEach(func(idx int, slot *ptrSlot[V]) {
+		ptr, gen, seq := slot.read()
+		if tag, ok := m.slotTag(idx, gen, seq); ok {
+			fn(tag, m.decode(ptr))
+		}
+	})
+}
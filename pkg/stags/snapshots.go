@@ -0,0 +1,47 @@
+package stags
+
+import "github.com/go-auxiliaries/tagmap"
+
+// counterSnapshots implements snapshots for counter maps on top of their forEach
+type counterSnapshots[N Counter] struct {
+	registryView
+	// forEach calls fn for every counter that is not zero, if reset is true counters are reset to zero on the way
+	forEach func(reset bool, fn func(tag tagmap.Tag, val N))
+}
+
+// SnapshotByTag returns all counters that are not zero
+func (m *counterSnapshots[N]) SnapshotByTag() map[tagmap.Tag]N {
+	out := make(map[tagmap.Tag]N)
+	m.forEach(false, func(tag tagmap.Tag, val N) {
+		out[tag] = val
+	})
+	return out
+}
+
+// SnapshotByName returns all counters that are not zero
+func (m *counterSnapshots[N]) SnapshotByName() map[tagmap.TagName]N {
+	out := make(map[tagmap.TagName]N)
+	m.forEach(false, func(tag tagmap.Tag, val N) {
+		out[m.name(tag)] = val
+	})
+	return out
+}
+
+// SnapshotAndResetByTag returns all counters that are not zero and resets them,
+// every update is counted exactly once, either in the result or in the counter afterwards
+func (m *counterSnapshots[N]) SnapshotAndResetByTag() map[tagmap.Tag]N {
+	out := make(map[tagmap.Tag]N)
+	m.forEach(true, func(tag tagmap.Tag, val N) {
+		out[tag] = val
+	})
+	return out
+}
+
+// SnapshotAndResetByName does the same as SnapshotAndResetByTag
+func (m *counterSnapshots[N]) SnapshotAndResetByName() map[tagmap.TagName]N {
+	out := make(map[tagmap.TagName]N)
+	m.forEach(true, func(tag tagmap.Tag, val N) {
+		out[m.name(tag)] = val
+	})
+	return out
+}
@@ -1,110 +1,194 @@
 package stags
 
 import (
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/go-auxiliaries/tagmap"
 	"github.com/go-auxiliaries/tagmap/pkg/registry"
+	"github.com/go-auxiliaries/tagmap/pkg/tags"
 )
 
+// SafeTagMap is a thread-safe map with values indexed by tags.
+// Tags registered after the map was created are supported, the map grows transparently on first write.
+// Word sized values (ints, bools, floats, pointers) are stored without allocations, see storage.
 type SafeTagMap[V any] struct {
-	values   []*V
-	registry *registry.TagRegistry
+	storage     storage
+	ptrs        *table[ptrSlot[V]]
+	words       *table[wordSlot]
+	nilPointer  *V
+	gate        *gate
+	watchers    watchers[V]
+	calls       calls[V]
+	watchBuffer int
+	registryView
 }
 
-func New[V any](r *registry.TagRegistry) *SafeTagMap[V] {
-	return &SafeTagMap[V]{
-		registry: r,
-		values:   make([]*V, r.GetLen()),
-	}
-}
-
-func (m *SafeTagMap[V]) IsTagName(name tagmap.TagName) bool {
-	return m.registry.GetTag(name) != tagmap.UnknownTag
-}
-
-func (m *SafeTagMap[V]) TagByName(name tagmap.TagName) tagmap.Tag {
-	return m.registry.GetTag(name)
-}
+var _ tagmap.Map[int] = (*SafeTagMap[int])(nil)
 
-func (m *SafeTagMap[V]) getTag(name tagmap.TagName) tagmap.Tag {
-	tag := m.TagByName(name)
-	if tag == tagmap.UnknownTag {
-		panic("there is no such tag with name " + name)
+// New creates map for all tags of the registry
+// !! It will fail if RequireSealed is given and registry is not sealed,
+// or if Defaults is given and default of a tag does not fit V !!
+func New[V any](r *registry.TagRegistry, opts ...Option) *SafeTagMap[V] {
+	o := options{watchBuffer: defaultWatchBuffer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &SafeTagMap[V]{
+		storage:      storageOf[V](),
+		nilPointer:   (*V)(unsafe.Pointer(&nilPointer)),
+		gate:         newGate(o.consistent),
+		watchBuffer:  o.watchBuffer,
+		registryView: newRegistryView(r, o.requireSealed),
+	}
+	if m.storage == storageWord {
+		m.words = newTable[wordSlot](r.GetLen(), o.padded)
+	} else {
+		m.ptrs = newTable[ptrSlot[V]](r.GetLen(), o.padded)
+	}
+	if o.defaults {
+		defaults, err := registry.DefaultsOf[V](r)
+		if err != nil {
+			panic(err)
+		}
+		for tag, val := range defaults {
+			m.swap(tag, val, true)
+		}
 	}
-	return tag
+	return m
 }
 
 // GetByName gets tag value by tag name
 // !! It will fail if tag is unknown !!
-// Make sure you validated tag name via IsTagName
+// Make sure you validated tag name via IsTagName or use LoadByName
 func (m *SafeTagMap[V]) GetByName(name tagmap.TagName) V {
 	return m.GetByTag(m.getTag(name))
 }
 
 // SetByName sets tag value by tag name
 // !! It will fail if tag is unknown !!
-// Make sure you validated tag name via IsTagName
+// Make sure you validated tag name via IsTagName or use StoreByName
 func (m *SafeTagMap[V]) SetByName(name tagmap.TagName, val V) {
 	m.SetByTag(m.getTag(name), val)
 }
 
+// SetByName2 does the same as SetByTag2
+//
+// Deprecated: use SetByName, see SetByTag2.
 func (m *SafeTagMap[V]) SetByName2(name tagmap.TagName, val *V) {
 	m.SetByTag2(m.getTag(name), val)
 }
 
+// Load gets tag value and reports whether it was set
+func (m *SafeTagMap[V]) Load(tag tagmap.Tag) (V, bool) {
+	return m.load(tag)
+}
+
+// Has reports whether tag value was set
+func (m *SafeTagMap[V]) Has(tag tagmap.Tag) bool {
+	_, ok := m.load(tag)
+	return ok
+}
+
 func (m *SafeTagMap[V]) GetByTag(tag tagmap.Tag) V {
-	val := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))
-	if val == unsafe.Pointer(nil) {
-		return *new(V)
-	}
-	return *(*V)(val)
+	val, _ := m.load(tag)
+	return val
 }
 
 func (m *SafeTagMap[V]) GetByNameOrSet(name tagmap.TagName, val V) (V, bool) {
 	return m.GetByTagOrSet(m.getTag(name), val)
 }
 
+// GetByNameOrSet2 does the same as GetByTagOrSet2
+//
+// Deprecated: use GetByNameOrSet, see GetByTagOrSet2.
 func (m *SafeTagMap[V]) GetByNameOrSet2(name tagmap.TagName, val *V) (*V, bool) {
 	return m.GetByTagOrSet2(m.getTag(name), val)
 }
 
+// GetByTagOrSet returns tag value if it is set, otherwise it sets val and returns it,
+// second result reports whether value was loaded, same as sync.Map.LoadOrStore
 func (m *SafeTagMap[V]) GetByTagOrSet(tag tagmap.Tag, val V) (V, bool) {
-	ok := atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(nil), unsafe.Pointer(&val))
-	if ok {
-		return val, false
-	}
-	return *(*V)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))), true
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.loadOrStore(tag, val)
 }
 
+// GetByTagOrSet2 does the same as GetByTagOrSet, but stores val pointer as is.
+// Only values that are boxed (see storage) are stored by pointer, word sized values and pointers are stored inline,
+// so for them *val is copied and returned pointer points to a copy, changes made via it are not visible in the map.
+//
+// Deprecated: use GetByTagOrSet, pointer semantics no longer hold for all value types.
 func (m *SafeTagMap[V]) GetByTagOrSet2(tag tagmap.Tag, val *V) (*V, bool) {
-	ok := atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(nil), unsafe.Pointer(val))
-	if ok {
+	if m.storage != storageBoxed {
+		actual, loaded := m.GetByTagOrSet(tag, *val)
+		return &actual, loaded
+	}
+	m.gate.enter()
+	defer m.gate.leave()
+	if actual := m.loadPtr(tag); actual != nil {
+		return actual, true
+	}
+	actual, stored := m.updatePtr(tag, true, func(actual *V, _ uint64) (*V, bool) {
+		return val, actual == nil
+	})
+	if stored {
 		return val, false
 	}
-	return (*V)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))), true
+	return actual, true
 }
 
 func (m *SafeTagMap[V]) GetByNameAndDelete(name tagmap.TagName) V {
 	return m.GetByTagAndDelete(m.getTag(name))
 }
 
+// GetByTagAndDelete deletes tag value and returns the previous one,
+// when called concurrently only one of callers gets the value
 func (m *SafeTagMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
-	val := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(nil))
-	if val == unsafe.Pointer(nil) {
-		return *new(V)
-	}
-	return *(*V)(val)
+	m.gate.enter()
+	defer m.gate.leave()
+	val, _ := m.swap(tag, *new(V), false)
+	return val
+}
+
+// SwapByName does the same as SwapByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) SwapByName(name tagmap.TagName, val V) (V, bool) {
+	return m.SwapByTag(m.getTag(name), val)
+}
+
+// SwapByTag sets tag value and returns the previous one,
+// second result reports whether value was set, same as sync.Map.Swap
+func (m *SafeTagMap[V]) SwapByTag(tag tagmap.Tag, val V) (V, bool) {
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.swap(tag, val, true)
 }
 
 func (m *SafeTagMap[V]) SetByTag(tag tagmap.Tag, val V) {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(&val))
+	m.gate.enter()
+	defer m.gate.leave()
+	m.swap(tag, val, true)
 }
 
+// SetByTag2 does the same as SetByTag, but stores val pointer as is.
+// Only values that are boxed (see storage) are stored by pointer, word sized values and pointers are stored inline,
+// so for them *val is copied and later changes made via val are not visible in the map.
+//
+// Deprecated: use SetByTag, pointer semantics no longer hold for all value types.
 func (m *SafeTagMap[V]) SetByTag2(tag tagmap.Tag, val *V) {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(val))
+	if m.storage != storageBoxed {
+		m.SetByTag(tag, *val)
+		return
+	}
+	m.setPtr(tag, val)
+}
+
+// setPtr stores val pointer as is, values must be boxed
+func (m *SafeTagMap[V]) setPtr(tag tagmap.Tag, val *V) {
+	m.gate.enter()
+	defer m.gate.leave()
+	m.swapPtr(tag, val)
 }
 
 func (m *SafeTagMap[V]) DeleteByName(name tagmap.TagName) {
@@ -112,28 +196,175 @@ func (m *SafeTagMap[V]) DeleteByName(name tagmap.TagName) {
 }
 
 func (m *SafeTagMap[V]) DeleteByTag(tag tagmap.Tag) {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])), unsafe.Pointer(nil))
+	m.gate.enter()
+	defer m.gate.leave()
+	m.swap(tag, *new(V), false)
 }
 
-func (m *SafeTagMap[V]) ValuesByTag() map[tagmap.Tag]V {
-	out := make(map[tagmap.Tag]V, len(m.values))
-	for tag := range m.values {
-		value := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))
-		if value != nil {
-			out[tagmap.Tag(tag)] = *(*V)(value)
-		}
+// LoadByName gets tag value by tag name, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) LoadByName(name tagmap.TagName) (V, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
+	}
+	return m.GetByTag(tag), nil
+}
+
+// StoreByName sets tag value by tag name, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) StoreByName(name tagmap.TagName, val V) error {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return err
+	}
+	m.SetByTag(tag, val)
+	return nil
+}
+
+// LoadOrStoreByName does the same as GetByNameOrSet, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) LoadOrStoreByName(name tagmap.TagName, val V) (V, bool, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), false, err
+	}
+	actual, loaded := m.GetByTagOrSet(tag, val)
+	return actual, loaded, nil
+}
+
+// LoadAndDeleteByName does the same as GetByNameAndDelete, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) LoadAndDeleteByName(name tagmap.TagName) (V, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
 	}
+	return m.GetByTagAndDelete(tag), nil
+}
+
+// RemoveByName does the same as DeleteByName, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) RemoveByName(name tagmap.TagName) error {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return err
+	}
+	m.DeleteByTag(tag)
+	return nil
+}
+
+// CompareAndSwapByName does the same as CompareAndSwapByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) CompareAndSwapByName(name tagmap.TagName, old, new V) bool {
+	return m.CompareAndSwapByTag(m.getTag(name), old, new)
+}
+
+// CompareAndSwapByTag sets tag value to new if it is set and equal to old, same as sync.Map.CompareAndSwap
+// !! It will fail if V is not comparable !!
+func (m *SafeTagMap[V]) CompareAndSwapByTag(tag tagmap.Tag, old, new V) bool {
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.compareAndSwap(tag, old, new, true)
+}
+
+// CompareAndDeleteByName does the same as CompareAndDeleteByTag
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) CompareAndDeleteByName(name tagmap.TagName, old V) bool {
+	return m.CompareAndDeleteByTag(m.getTag(name), old)
+}
+
+// CompareAndDeleteByTag deletes tag value if it is equal to old, same as sync.Map.CompareAndDelete
+// !! It will fail if V is not comparable !!
+func (m *SafeTagMap[V]) CompareAndDeleteByTag(tag tagmap.Tag, old V) bool {
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.compareAndSwap(tag, old, *new(V), false)
+}
+
+// LoadVersioned gets tag value along with version of the tag, version grows with every write to the tag, including deletes,
+// it can be passed to StoreIfVersion to make sure the tag was not changed meanwhile.
+// Versions are 61 bits wide, so they do not wrap in practice.
+func (m *SafeTagMap[V]) LoadVersioned(tag tagmap.Tag) (V, uint64) {
+	val, _, ver := m.loadVersioned(tag)
+	return val, ver
+}
+
+// LoadVersionedByName does the same as LoadVersioned
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) LoadVersionedByName(name tagmap.TagName) (V, uint64) {
+	return m.LoadVersioned(m.getTag(name))
+}
+
+// StoreIfVersion sets tag value if version of the tag is equal to expected, it reports whether value was set.
+// Unlike CompareAndSwapByTag it works for values that are not comparable.
+// Version of a tag that was never written is 0.
+func (m *SafeTagMap[V]) StoreIfVersion(tag tagmap.Tag, val V, expected uint64) bool {
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.storeIfVersion(tag, val, true, expected)
+}
+
+// StoreIfVersionByName does the same as StoreIfVersion
+// !! It will fail if tag is unknown !!
+// Make sure you validated tag name via IsTagName
+func (m *SafeTagMap[V]) StoreIfVersionByName(name tagmap.TagName, val V, expected uint64) bool {
+	return m.StoreIfVersion(m.getTag(name), val, expected)
+}
+
+// DeleteIfVersion deletes tag value if version of the tag is equal to expected, it reports whether value was deleted
+func (m *SafeTagMap[V]) DeleteIfVersion(tag tagmap.Tag, expected uint64) bool {
+	m.gate.enter()
+	defer m.gate.leave()
+	return m.storeIfVersion(tag, *new(V), false, expected)
+}
+
+// Snapshot returns a copy of all values taken at a single point in time:
+// every write that returned before the call is in the copy, none that started after it is.
+// Unlike ValuesByTag, it never shows one tag before a write and another one after it.
+// Writes are held while the copy is taken.
+// !! It will fail if map was created without Consistent option !!
+func (m *SafeTagMap[V]) Snapshot() *tags.TagMap[V] {
+	if m.gate == nil {
+		panic("stags: Snapshot requires Consistent option")
+	}
+	out := tags.New[V](m.registry)
+	m.gate.lock()
+	defer m.gate.unlock()
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out.SetByTag(tag, val)
+	})
 	return out
 }
 
-func (m *SafeTagMap[V]) ValuesByName() map[tagmap.TagName]V {
-	out := make(map[tagmap.TagName]V, len(m.values))
-	for tag := range m.values {
-		value := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.values[tag])))
-		if value != nil {
-			out[m.registry.GetName(tagmap.Tag(tag))] = *(*V)(value)
-		}
+// Remap returns a new map over the target registry of remapping with values of all tags that have translation,
+// values are loaded independently, same as in ValuesByTag
+// !! It will fail if map is not built from the source registry of remapping !!
+func (m *SafeTagMap[V]) Remap(rm *registry.Remapping, opts ...Option) *SafeTagMap[V] {
+	if rm.From() != m.registry {
+		panic("stags: Remap requires remapping from the registry of the map")
 	}
+	out := New[V](rm.To(), opts...)
+	m.forEach(func(tag tagmap.Tag, val V) {
+		if tag = rm.Tag(tag); tag != tagmap.UnknownTag {
+			out.swap(tag, val, true)
+		}
+	})
+	return out
+}
+
+// ValuesByTag returns all values, each of them is loaded independently, use Snapshot for a consistent view
+func (m *SafeTagMap[V]) ValuesByTag() map[tagmap.Tag]V {
+	out := make(map[tagmap.Tag]V, m.registry.GetLen())
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[tag] = val
+	})
+	return out
+}
+
+func (m *SafeTagMap[V]) ValuesByName() map[tagmap.TagName]V {
+	out := make(map[tagmap.TagName]V, m.registry.GetLen())
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[m.name(tag)] = val
+	})
 	return out
 }
 
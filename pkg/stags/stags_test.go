@@ -1,10 +1,18 @@
 package stags_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/internal/conformance"
 
 	"github.com/go-auxiliaries/tagmap/pkg/registry"
 	"github.com/go-auxiliaries/tagmap/pkg/stags"
@@ -96,3 +104,846 @@ func TestParallel(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestGrow(t *testing.T) {
+	r := registry.New()
+	early := r.RegisterTag("early")
+	m := stags.New[int](r)
+	m.SetByTag(early, 1)
+
+	late := make([]tagmap.Tag, 0, 100)
+	for n := 0; n < 100; n++ {
+		late = append(late, r.RegisterTag(tagmap.TagName(strconv.Itoa(n))))
+	}
+	assert.Equal(t, 0, m.GetByTag(late[99]))
+	assert.Equal(t, 0, m.GetByTagAndDelete(late[99]))
+	m.DeleteByTag(late[99])
+
+	wg := sync.WaitGroup{}
+	for n := range late {
+		wg.Add(1)
+		go func(n int) {
+			m.SetByTag(late[n], n)
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+	for n, tag := range late {
+		assert.Equal(t, n, m.GetByTag(tag))
+		assert.Equal(t, n, m.GetByName(tagmap.TagName(strconv.Itoa(n))))
+	}
+	assert.Equal(t, 1, m.GetByTag(early))
+	assert.Len(t, m.ValuesByTag(), 101)
+	assert.Equal(t, 42, m.ValuesByName()["42"])
+	assert.Panics(t, func() { m.SetByTag(tagmap.Tag(101), 0) })
+}
+
+func TestRequireSealed(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	assert.PanicsWithValue(t, tagmap.ErrNotSealed, func() { stags.New[int](r, stags.RequireSealed()) })
+	r.Seal()
+	m := stags.New[int](r, stags.RequireSealed())
+	m.SetByTag(tag, 1)
+	assert.Equal(t, map[tagmap.TagName]int{"tag": 1}, m.ValuesByName())
+}
+
+func TestErrors(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[string](r)
+
+	assert.NoError(t, m.StoreByName("tag", "val"))
+	val, err := m.LoadByName("tag")
+	assert.NoError(t, err)
+	assert.Equal(t, "val", val)
+	val, loaded, err := m.LoadOrStoreByName("tag", "other")
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, "val", val)
+	val, err = m.LoadAndDeleteByName("tag")
+	assert.NoError(t, err)
+	assert.Equal(t, "val", val)
+	assert.Equal(t, "", m.GetByTag(tag))
+	assert.NoError(t, m.RemoveByName("tag"))
+
+	_, err = m.LoadByName("unknown")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.StoreByName("unknown", "val"), tagmap.ErrUnknownTag)
+	_, _, err = m.LoadOrStoreByName("unknown", "val")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	_, err = m.LoadAndDeleteByName("unknown")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.RemoveByName("unknown"), tagmap.ErrUnknownTag)
+
+	defer func() {
+		assert.ErrorIs(t, recover().(error), tagmap.ErrUnknownTag)
+	}()
+	m.GetByName("unknown")
+}
+
+func TestGetOrSet(t *testing.T) {
+	conformance.TestGetOrSet(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return stags.New[string](r)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	conformance.TestLoad(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return stags.New[string](r)
+	})
+}
+
+func TestUnregister(t *testing.T) {
+	conformance.TestUnregister(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return stags.New[string](r)
+	})
+
+	r := registry.New()
+	old := r.RegisterTag("old")
+	m := stags.New[int](r)
+	counters := stags.NewCounters[int64](r)
+	m.SetByTag(old, 1)
+	counters.IncByTag(old)
+	r.Unregister("old")
+	assert.Empty(t, counters.SnapshotByName())
+	tag := r.RegisterTag("new")
+	assert.False(t, m.Has(tag))
+	_, ver := m.LoadVersioned(tag)
+	assert.True(t, m.StoreIfVersion(tag, 2, ver))
+	assert.Equal(t, 0, m.GetByTag(old))
+	assert.Panics(t, func() { m.SetByTag(old, 3) })
+	assert.False(t, m.CompareAndSwapByTag(old, 1, 3))
+	assert.Equal(t, map[tagmap.TagName]int{"new": 2}, m.ValuesByName())
+	assert.Empty(t, counters.SnapshotByName(), "counter of the unregistered tag is not inherited")
+}
+
+func TestUnregisterCounters(t *testing.T) {
+	r := registry.New()
+	staleA := r.RegisterTag("tenantA")
+	counters := stags.NewCounters[int64](r)
+	sharded := stags.NewShardedCounters[int64](r, 4)
+	counters.AddByTag(staleA, 5)
+	sharded.AddByTag(staleA, 5)
+	r.Unregister("tenantA")
+	assert.Equal(t, int64(0), counters.GetByTag(staleA))
+	assert.Equal(t, int64(0), sharded.GetByTag(staleA))
+	assert.Panics(t, func() { counters.IncByTag(staleA) })
+	assert.Panics(t, func() { sharded.IncByTag(staleA) })
+
+	tenantB := r.RegisterTag("tenantB")
+	assert.Equal(t, staleA.Index(), tenantB.Index())
+	assert.Equal(t, int64(0), counters.GetByTag(tenantB))
+	assert.Equal(t, int64(0), sharded.GetByTag(tenantB))
+	assert.PanicsWithError(t, fmt.Sprintf("%s: %d", tagmap.ErrStaleTag, staleA), func() { counters.IncByTag(staleA) })
+	assert.Panics(t, func() { sharded.IncByTag(staleA) })
+	assert.Panics(t, func() { counters.CompareAndSwapByTag(staleA, 0, 1) })
+	assert.Equal(t, int64(0), counters.ResetByTag(staleA))
+
+	assert.Equal(t, int64(1), counters.IncByTag(tenantB))
+	sharded.IncByTag(tenantB)
+	assert.Equal(t, int64(0), counters.GetByTag(staleA))
+	assert.Equal(t, int64(0), sharded.GetByTag(staleA))
+	assert.Panics(t, func() { counters.IncByTag(staleA) })
+	assert.Equal(t, map[tagmap.TagName]int64{"tenantB": 1}, counters.SnapshotByName())
+	assert.Equal(t, map[tagmap.TagName]int64{"tenantB": 1}, sharded.SnapshotAndResetByName())
+	assert.Equal(t, int64(0), sharded.GetByTag(tenantB))
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[string](r)
+
+	assert.False(t, m.CompareAndSwapByTag(tag, "", "val1"))
+	assert.False(t, m.CompareAndDeleteByTag(tag, ""))
+	m.SetByTag(tag, "val1")
+	assert.False(t, m.CompareAndSwapByTag(tag, "other", "val2"))
+	assert.True(t, m.CompareAndSwapByTag(tag, "val1", "val2"))
+	assert.Equal(t, "val2", m.GetByTag(tag))
+	assert.True(t, m.CompareAndSwapByName("tag", "val2", "val3"))
+	assert.False(t, m.CompareAndDeleteByName("tag", "val2"))
+	assert.True(t, m.CompareAndDeleteByName("tag", "val3"))
+	_, loaded := m.GetByTagOrSet(tag, "val4")
+	assert.False(t, loaded)
+
+	late := r.RegisterTag("late")
+	assert.False(t, m.CompareAndSwapByTag(late, "", "val"))
+
+	assert.Panics(t, func() {
+		m := stags.New[any](r)
+		m.SetByTag(tag, []int{})
+		m.CompareAndSwapByTag(tag, []int{}, nil)
+	})
+}
+
+func TestCompareAndSwapParallel(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[int](r)
+	m.SetByTag(tag, 0)
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			for k := 0; k < 100; k++ {
+				for {
+					val := m.GetByTag(tag)
+					if m.CompareAndSwapByTag(tag, val, val+1) {
+						break
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1000, m.GetByTag(tag))
+}
+
+func TestSwap(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[string](r)
+
+	prev, loaded := m.SwapByTag(tag, "val1")
+	assert.False(t, loaded)
+	assert.Equal(t, "", prev)
+	prev, loaded = m.SwapByName("tag", "val2")
+	assert.True(t, loaded)
+	assert.Equal(t, "val1", prev)
+	assert.Equal(t, "val2", m.GetByTag(tag))
+}
+
+func TestGetAndDeleteParallel(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[int](r)
+	received := make(chan int, 1000)
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func(n int) {
+			for k := 1; k <= 100; k++ {
+				for {
+					if _, loaded := m.GetByTagOrSet(tag, n*100+k); !loaded {
+						break
+					}
+					runtime.Gosched()
+				}
+			}
+			wg.Done()
+		}(n)
+		go func() {
+			for k := 0; k < 100; k++ {
+				for {
+					if val := m.GetByTagAndDelete(tag); val != 0 {
+						received <- val
+						break
+					}
+					runtime.Gosched()
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	close(received)
+	seen := map[int]bool{}
+	for val := range received {
+		assert.False(t, seen[val], "value %d received twice", val)
+		seen[val] = true
+	}
+	assert.Len(t, seen, 1000)
+}
+
+func TestWordStorage(t *testing.T) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+
+	ints := stags.New[int8](r)
+	ints.SetByTag(tag1, -1)
+	assert.Equal(t, int8(-1), ints.GetByTag(tag1))
+	val, loaded := ints.GetByTagOrSet(tag2, 0)
+	assert.False(t, loaded)
+	assert.Equal(t, int8(0), val)
+	assert.True(t, ints.Has(tag2))
+	assert.Equal(t, map[tagmap.Tag]int8{tag1: -1, tag2: 0}, ints.ValuesByTag())
+	assert.True(t, ints.CompareAndSwapByTag(tag1, -1, 5))
+	assert.False(t, ints.CompareAndDeleteByTag(tag1, -1))
+	assert.True(t, ints.CompareAndDeleteByTag(tag1, 5))
+	assert.False(t, ints.Has(tag1))
+
+	floats := stags.New[float64](r)
+	prev, loaded := floats.SwapByTag(tag1, 1.5)
+	assert.False(t, loaded)
+	assert.Equal(t, 0.0, prev)
+	assert.Equal(t, 1.5, floats.GetByTagAndDelete(tag1))
+	assert.Empty(t, floats.ValuesByName())
+
+	bools := stags.New[bool](r)
+	bools.SetByTag2(tag1, new(bool))
+	ptr, loaded := bools.GetByTagOrSet2(tag1, new(bool))
+	assert.True(t, loaded)
+	assert.False(t, *ptr)
+	assert.True(t, bools.Has(tag1))
+	*ptr = true
+	assert.False(t, bools.GetByTag(tag1), "word sized values are copied, not kept by pointer")
+}
+
+func TestDirectStorage(t *testing.T) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := stags.New[*int](r)
+
+	one, two := 1, 2
+	m.SetByTag(tag1, &one)
+	assert.Same(t, &one, m.GetByTag(tag1))
+	m.SetByTag(tag2, nil)
+	val, ok := m.Load(tag2)
+	assert.True(t, ok)
+	assert.Nil(t, val)
+	val, loaded := m.GetByTagOrSet(tag2, &two)
+	assert.True(t, loaded)
+	assert.Nil(t, val)
+	assert.True(t, m.CompareAndSwapByTag(tag2, nil, &two))
+	assert.Same(t, &two, m.GetByTag(tag2))
+	assert.Equal(t, map[tagmap.Tag]*int{tag1: &one, tag2: &two}, m.ValuesByTag())
+	assert.Same(t, &one, m.GetByTagAndDelete(tag1))
+	assert.False(t, m.Has(tag1))
+}
+
+func TestWordStorageParallel(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[int](r)
+	m.SetByTag(tag, 0)
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func() {
+			for k := 0; k < 100; k++ {
+				for {
+					val := m.GetByTag(tag)
+					if m.CompareAndSwapByTag(tag, val, val+1) {
+						break
+					}
+				}
+			}
+			wg.Done()
+		}()
+		go func() {
+			for k := 0; k < 100; k++ {
+				m.Has(tag)
+				m.ValuesByTag()
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1000, m.GetByTag(tag))
+}
+
+func TestSnapshot(t *testing.T) {
+	r := registry.New()
+	first := r.RegisterTag("first")
+	second := r.RegisterTag("second")
+	m := stags.New[int](r, stags.Consistent())
+
+	done := make(chan struct{})
+	go func() {
+		for n := 1; n <= 10000; n++ {
+			m.SetByTag(first, n)
+			m.SetByTag(second, n)
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		snapshot := m.Snapshot()
+		assert.GreaterOrEqual(t, snapshot.GetByTag(first), snapshot.GetByTag(second))
+	}
+	assert.Equal(t, map[tagmap.TagName]int{"first": 10000, "second": 10000}, m.Snapshot().ValuesByName())
+
+	assert.Panics(t, func() { stags.New[int](r).Snapshot() })
+}
+
+func TestUpdate(t *testing.T) {
+	r := registry.New()
+	from := r.RegisterTag("from")
+	to := r.RegisterTag("to")
+	m := stags.New[int](r, stags.Consistent())
+	m.SetByTag(from, 1000)
+	m.SetByTag(to, 0)
+
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func() {
+			for k := 0; k < 100; k++ {
+				assert.NoError(t, m.Update(func(tx *stags.Tx[int]) error {
+					tx.SetByTag(from, tx.GetByTag(from)-1)
+					tx.SetByName("to", tx.GetByName("to")+1)
+					return nil
+				}))
+			}
+			wg.Done()
+		}()
+		go func() {
+			for k := 0; k < 100; k++ {
+				snapshot := m.Snapshot()
+				assert.Equal(t, 1000, snapshot.GetByTag(from)+snapshot.GetByTag(to))
+				assert.NoError(t, m.Update(func(tx *stags.Tx[int]) error {
+					sum := tx.GetByTag(from) + tx.GetByTag(to)
+					if sum != 1000 {
+						return fmt.Errorf("sum is %d", sum)
+					}
+					return nil
+				}))
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, m.GetByTag(from))
+	assert.Equal(t, 1000, m.GetByTag(to))
+
+	errAbort := errors.New("abort")
+	assert.ErrorIs(t, m.Update(func(tx *stags.Tx[int]) error {
+		tx.DeleteByTag(from)
+		_, ok := tx.Load(from)
+		assert.False(t, ok)
+		return errAbort
+	}), errAbort)
+	assert.True(t, m.Has(from))
+
+	assert.Panics(t, func() {
+		_ = stags.New[int](r).Update(func(tx *stags.Tx[int]) error { return nil })
+	})
+}
+
+func TestWatch(t *testing.T) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := stags.New[string](r, stags.WatchBuffer(3))
+
+	all := m.Watch()
+	one := m.Watch(tag1)
+	m.SetByTag(tag1, "val1")
+	m.DeleteByTag(tag2)
+	_, _ = m.GetByTagOrSet(tag2, "val2")
+	m.SwapByTag(tag1, "val3")
+	m.GetByTagAndDelete(tag1)
+
+	assert.Equal(t, stags.Change[string]{Tag: tag1, New: "val1", Version: 1}, <-all)
+	assert.Equal(t, stags.Change[string]{Tag: tag2, New: "val2", Version: 1}, <-all)
+	assert.Equal(t, stags.Change[string]{Tag: tag1, Old: "val1", Existed: true, New: "val3", Version: 2}, <-all)
+	assert.Empty(t, all, "changes that do not fit into buffer are dropped")
+	m.CompareAndSwapByTag(tag2, "val2", "val4")
+	assert.Equal(t, stags.Change[string]{Tag: tag2, Old: "val2", Existed: true, New: "val4", Version: 2, Dropped: 1}, <-all)
+
+	assert.Equal(t, stags.Change[string]{Tag: tag1, New: "val1", Version: 1}, <-one)
+	assert.Equal(t, stags.Change[string]{Tag: tag1, Old: "val1", Existed: true, New: "val3", Version: 2}, <-one)
+	assert.Equal(t, stags.Change[string]{Tag: tag1, Old: "val3", Existed: true, Deleted: true, Version: 3}, <-one)
+
+	m.Unwatch(all)
+	m.Unwatch(one)
+	_, open := <-all
+	assert.False(t, open)
+	m.SetByTag(tag1, "val5")
+
+	changes := make(chan stags.Change[string], 10)
+	stop := m.OnChange(func(c stags.Change[string]) { changes <- c }, tag2)
+	m.SetByTag(tag1, "val6")
+	m.DeleteByTag(tag2)
+	assert.Equal(t, stags.Change[string]{Tag: tag2, Old: "val4", Existed: true, Deleted: true, Version: 3}, <-changes)
+	stop()
+	stop()
+	m.SetByTag(tag2, "val7")
+	assert.Empty(t, changes)
+
+	assert.Panics(t, func() { m.Watch(tagmap.Tag(2)) })
+}
+
+func TestWait(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[int](r)
+
+	proceed := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.SetByTag(tag, 0)
+		<-proceed
+		for n := 1; n <= 10; n++ {
+			m.SetByTag(tag, n)
+		}
+	}()
+	val, err := m.WaitByName(context.Background(), "tag")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, val, "zero value counts as set")
+	close(proceed)
+	val, err = m.WaitByTagUntil(context.Background(), tag, func(val int, ok bool) bool {
+		return val == 10
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, val)
+
+	m.DeleteByTag(tag)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.WaitByTag(ctx, tag)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = m.WaitByName(ctx, "unknown")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+
+	// waiters do not depend on buffer of watch channels
+	for _, buffer := range []int{-1, 0} {
+		m := stags.New[int](r, stags.WatchBuffer(buffer))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for n := 1; n <= 1000; n++ {
+				m.SetByTag(tag, n)
+			}
+		}()
+		val, err := m.WaitByTagUntil(context.Background(), tag, func(val int, ok bool) bool {
+			return val == 1000
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1000, val)
+		<-done
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	r := registry.New()
+	short := r.RegisterTag("short")
+	long := r.RegisterTag("long")
+	forever := r.RegisterTag("forever")
+	m := stags.NewExpiring[string](r, 50*time.Millisecond)
+	evicted := map[tagmap.Tag]string{}
+	m.OnEvict(func(tag tagmap.Tag, val string) { evicted[tag] = val })
+
+	m.SetByTag(short, "short")
+	m.SetByNameWithTTL("long", "long", time.Hour)
+	m.SetByTagWithTTL(forever, "forever", 0)
+	val, loaded := m.GetByTagOrSet(short, "other")
+	assert.True(t, loaded)
+	assert.Equal(t, "short", val)
+	assert.Equal(t, map[tagmap.TagName]string{"short": "short", "long": "long", "forever": "forever"}, m.ValuesByName())
+
+	time.Sleep(70 * time.Millisecond)
+	assert.False(t, m.Has(short))
+	assert.Equal(t, map[tagmap.Tag]string{short: "short"}, evicted)
+	assert.Equal(t, map[tagmap.Tag]string{long: "long", forever: "forever"}, m.ValuesByTag())
+	val, loaded = m.GetByTagOrSet(short, "again")
+	assert.False(t, loaded)
+	assert.Equal(t, "again", val)
+	assert.Equal(t, "long", m.GetByTagAndDelete(long))
+	assert.False(t, m.Has(long))
+
+	time.Sleep(70 * time.Millisecond)
+	assert.Equal(t, 1, m.Evict())
+	assert.Equal(t, map[tagmap.Tag]string{short: "again"}, evicted)
+	assert.Equal(t, tagmap.List[string]{"", "", "forever"}, m.GetValuesByTag(short, long, forever))
+}
+
+func TestExpiringJanitor(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.NewExpiring[int](r, time.Millisecond)
+	evicted := make(chan int, 1)
+	m.OnEvict(func(_ tagmap.Tag, val int) { evicted <- val })
+	stop := m.StartJanitor(time.Millisecond)
+	defer stop()
+
+	m.SetByTag(tag, 1)
+	assert.Equal(t, 1, <-evicted)
+	_, loaded := m.GetByTagOrSet(tag, 2)
+	assert.False(t, loaded)
+}
+
+func TestGetOrCompute(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[string](r)
+
+	errFailed := errors.New("failed")
+	_, err := m.GetOrComputeByTag(tag, func() (string, error) { return "", errFailed })
+	assert.ErrorIs(t, err, errFailed)
+	assert.False(t, m.Has(tag), "errors are not stored")
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			val, err := m.GetOrComputeByName("tag", func() (string, error) {
+				calls.Add(1)
+				<-release
+				return "val", nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "val", val)
+			wg.Done()
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, "val", m.GetByTag(tag))
+
+	other := r.RegisterTag("other")
+	started := make(chan struct{})
+	waited := make(chan error)
+	assert.Panics(t, func() {
+		_, _ = m.GetOrComputeByTag(other, func() (string, error) {
+			go func() {
+				_, err := m.GetOrComputeByTag(other, func() (string, error) { return "", nil })
+				waited <- err
+			}()
+			close(started)
+			time.Sleep(10 * time.Millisecond)
+			panic("boom")
+		})
+	})
+	<-started
+	err = <-waited
+	if err != nil {
+		assert.ErrorIs(t, err, tagmap.ErrComputePanicked)
+	}
+	assert.Panics(t, func() { _, _ = m.GetOrComputeByTag(tagmap.Tag(2), nil) })
+}
+
+func TestVersions(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := stags.New[[]int](r)
+
+	val, ver := m.LoadVersioned(tag)
+	assert.Nil(t, val)
+	assert.Equal(t, uint64(0), ver)
+	assert.False(t, m.DeleteIfVersion(tag, 0), "there is nothing to delete")
+	assert.True(t, m.StoreIfVersion(tag, []int{1}, 0))
+	assert.False(t, m.StoreIfVersion(tag, []int{2}, 0))
+	val, ver = m.LoadVersionedByName("tag")
+	assert.Equal(t, []int{1}, val)
+	assert.Equal(t, uint64(1), ver)
+	m.SetByTag(tag, []int{3})
+	assert.False(t, m.StoreIfVersionByName("tag", []int{4}, ver))
+	assert.False(t, m.DeleteIfVersion(tag, ver))
+	assert.True(t, m.DeleteIfVersion(tag, ver+1))
+	_, ver = m.LoadVersioned(tag)
+	assert.Equal(t, uint64(3), ver)
+
+	counters := stags.New[[]int](r)
+	counters.SetByTag(tag, []int{0})
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func() {
+			for k := 0; k < 100; k++ {
+				for {
+					val, ver := counters.LoadVersioned(tag)
+					if counters.StoreIfVersion(tag, []int{val[0] + 1}, ver) {
+						break
+					}
+				}
+			}
+			wg.Done()
+		}()
+		// readers never see value of one write along with version of another
+		go func() {
+			for k := 0; k < 100; k++ {
+				val, ver := counters.LoadVersioned(tag)
+				assert.Equal(t, uint64(val[0]+1), ver)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []int{1000}, counters.GetByTag(tag))
+
+	// tag that reuses index of an unregistered one starts from version 0
+	x := r.RegisterTag("x")
+	ints := stags.New[int](r)
+	ints.SetByTag(x, 1)
+	ints.SetByTag(x, 2)
+	m.SetByTag(x, []int{1})
+	m.SetByTag(x, []int{2})
+	r.Unregister("x")
+	y := r.RegisterTag("y")
+	_, ver = ints.LoadVersioned(y)
+	assert.Equal(t, uint64(0), ver)
+	assert.True(t, ints.StoreIfVersion(y, 3, 0))
+	_, ver = m.LoadVersioned(y)
+	assert.Equal(t, uint64(0), ver)
+	assert.True(t, m.StoreIfVersion(y, []int{3}, 0))
+	val, ver = m.LoadVersioned(y)
+	assert.Equal(t, []int{3}, val)
+	assert.False(t, m.StoreIfVersion(y, []int{4}, 0))
+	assert.True(t, m.StoreIfVersion(y, []int{4}, ver))
+}
+
+func TestPadded(t *testing.T) {
+	r := registry.New()
+	early := r.RegisterTag("early")
+	ints := stags.New[int](r, stags.Padded())
+	strs := stags.New[string](r, stags.Padded())
+	ints.SetByTag(early, -1)
+	strs.SetByTag(early, "early")
+
+	late := make([]tagmap.Tag, 0, 100)
+	for n := 0; n < 100; n++ {
+		late = append(late, r.RegisterTag(tagmap.TagName(strconv.Itoa(n))))
+	}
+	for n, tag := range late {
+		ints.SetByTag(tag, n)
+		strs.SetByTag(tag, strconv.Itoa(n))
+	}
+	values := ints.ValuesByTag()
+	assert.Len(t, values, 101)
+	assert.Equal(t, -1, values[early])
+	for n, tag := range late {
+		assert.Equal(t, n, values[tag])
+		assert.Equal(t, strconv.Itoa(n), strs.GetByTag(tag))
+	}
+	assert.Equal(t, "early", strs.GetByName("early"))
+}
+
+func TestCounters(t *testing.T) {
+	r := registry.New()
+	hits := r.RegisterTag("hits")
+	misses := r.RegisterTag("misses")
+	c := stags.NewCounters[int64](r)
+
+	assert.Equal(t, int64(1), c.IncByTag(hits))
+	assert.Equal(t, int64(-4), c.AddByName("misses", -4))
+	assert.Equal(t, int64(-4), c.SwapByTag(misses, 10))
+	assert.False(t, c.CompareAndSwapByTag(misses, 0, 1))
+	assert.True(t, c.CompareAndSwapByName("misses", 10, 11))
+	assert.Equal(t, map[tagmap.TagName]int64{"hits": 1, "misses": 11}, c.SnapshotByName())
+	assert.Equal(t, int64(11), c.ResetByName("misses"))
+	assert.Equal(t, map[tagmap.Tag]int64{hits: 1}, c.SnapshotAndResetByTag())
+	assert.Empty(t, c.SnapshotByTag())
+
+	late := r.RegisterTag("late")
+	assert.Equal(t, int64(0), c.GetByTag(late))
+	assert.Equal(t, int64(0), c.ResetByTag(late))
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			for k := 0; k < 100; k++ {
+				c.IncByTag(late)
+				c.IncByName("hits")
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[tagmap.TagName]int64{"hits": 1000, "late": 1000}, c.SnapshotAndResetByName())
+	assert.Equal(t, int64(0), c.GetByName("late"))
+	assert.Panics(t, func() { c.IncByTag(tagmap.Tag(3)) })
+
+	u := stags.NewCounters[uint64](r, stags.Padded())
+	u.AddByTag(hits, 2)
+	assert.Equal(t, uint64(1), u.AddByTag(hits, ^uint64(0)), "adding max uint64 subtracts one")
+}
+
+func TestShardedCounters(t *testing.T) {
+	r := registry.New()
+	requests := r.RegisterTag("requests")
+	errs := r.RegisterTag("errors")
+	c := stags.NewShardedCounters[int64](r, 4)
+
+	c.IncByTag(requests)
+	c.AddByName("errors", -2)
+	assert.Equal(t, int64(1), c.GetByName("requests"))
+	assert.Equal(t, int64(-2), c.ResetByTag(errs))
+	assert.Equal(t, map[tagmap.Tag]int64{requests: 1}, c.SnapshotByTag())
+
+	late := r.RegisterTag("late")
+	wg := sync.WaitGroup{}
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			for k := 0; k < 100; k++ {
+				c.IncByTag(late)
+				c.IncByName("requests")
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1000), c.GetByTag(late))
+	assert.Equal(t, map[tagmap.TagName]int64{"requests": 1001, "late": 1000}, c.SnapshotAndResetByName())
+	assert.Empty(t, c.SnapshotByName())
+	assert.Equal(t, int64(0), c.ResetByName("late"))
+	assert.Panics(t, func() { c.IncByTag(tagmap.Tag(3)) })
+
+	single := stags.NewShardedCounters[uint64](r, 0)
+	single.AddByTag(late, 5)
+	assert.Equal(t, map[tagmap.Tag]uint64{late: 5}, single.SnapshotAndResetByTag())
+}
+
+func TestNamespace(t *testing.T) {
+	r := registry.New()
+	db := r.Namespace("db")
+	query := db.RegisterTag("query")
+	other := r.RegisterTag("other")
+	m := stags.New[int](db)
+	counters := stags.NewCounters[int64](db)
+	exec := db.RegisterTag("exec")
+
+	m.SetByTag(query, 1)
+	m.SetByName("db.exec", 2)
+	counters.IncByTag(exec)
+	assert.Equal(t, map[tagmap.TagName]int{"db.query": 1, "db.exec": 2}, m.ValuesByName())
+	assert.Equal(t, map[tagmap.Tag]int64{exec: 1}, counters.SnapshotByTag())
+	assert.Equal(t, 0, m.GetByTag(other))
+	assert.Panics(t, func() { m.SetByTag(other, 3) })
+	assert.Panics(t, func() { counters.IncByTag(other) })
+}
+
+func TestRemap(t *testing.T) {
+	from := registry.New()
+	a := from.RegisterTag("a")
+	b := from.RegisterTag("b")
+	m := stags.New[int](from)
+	m.SetByTag(a, 1)
+	m.SetByTag(b, 2)
+	to := registry.New()
+	to.RegisterTag("b")
+
+	rm, err := registry.Remap(from, to, registry.DropMissing)
+	assert.NoError(t, err)
+	remapped := m.Remap(rm, stags.Consistent())
+	assert.Equal(t, map[tagmap.TagName]int{"b": 2}, remapped.ValuesByName())
+	assert.Equal(t, map[tagmap.Tag]int{0: 2}, remapped.Snapshot().ValuesByTag())
+	assert.Panics(t, func() { remapped.Remap(rm) })
+}
+
+func TestDefaults(t *testing.T) {
+	r := registry.New()
+	r.RegisterTagWithMeta("timeout", registry.Meta{Default: 500})
+	r.RegisterTagWithMeta("retries", registry.Meta{Default: 3})
+
+	assert.Equal(t, map[tagmap.TagName]time.Duration{"timeout": 500, "retries": 3},
+		stags.New[time.Duration](r, stags.Defaults()).ValuesByName())
+	assert.Equal(t, map[tagmap.TagName]int64{"timeout": 500, "retries": 3}, stags.New[int64](r, stags.Defaults()).ValuesByName())
+	assert.Empty(t, stags.New[int](r).ValuesByName())
+
+	r.RegisterTagWithMeta("name", registry.Meta{Default: "1s"})
+	assert.Panics(t, func() { stags.New[int](r, stags.Defaults()) })
+}
@@ -0,0 +1,112 @@
+package stags
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	minChunkLen = 8
+	maxChunks   = bits.UintSize
+
+	cacheLineSize = 64
+)
+
+// table is an array of slots that can grow without locks and without moving slots.
+// The first chunk is sized to the registry at creation time and covers all tags known by then,
+// tags registered later land in chunks that are allocated on demand, each twice as big as the previous one.
+// Since slots never move, a writer can not lose its update to a concurrent resize.
+//
+// Slots are placed stride apart, stride greater than one keeps starts of neighbouring slots
+// at least a cache line apart (see Padded), the slots in between are never used.
+type table[S any] struct {
+	first  []S
+	n      int
+	base   int
+	stride int
+	more   [maxChunks]atomic.Pointer[[]S]
+}
+
+func newTable[S any](n int, padded bool) *table[S] {
+	base := n
+	if base < minChunkLen {
+		base = minChunkLen
+	}
+	stride := 1
+	if size := int(unsafe.Sizeof(*new(S))); padded && size < cacheLineSize {
+		// rounded up, otherwise e.g. 24 byte slots would get stride 2 and still share cache lines
+		stride = (cacheLineSize + size - 1) / size
+	}
+	return &table[S]{
+		first:  make([]S, n*stride),
+		n:      n,
+		base:   base,
+		stride: stride,
+	}
+}
+
+// covers reports whether slot is in the first chunk, such slots never need to grow
+func (t *table[S]) covers(idx int) bool {
+	return uint(idx) < uint(t.n)
+}
+
+// get returns slot by index, if the slot is not yet allocated it returns nil, so it does for negative indices
+func (t *table[S]) get(idx int) *S {
+	if t.covers(idx) {
+		return &t.first[idx*t.stride]
+	}
+	if idx < 0 {
+		return nil
+	}
+	k, off := t.locate(idx)
+	chunk := t.more[k].Load()
+	if chunk == nil {
+		return nil
+	}
+	return &(*chunk)[off*t.stride]
+}
+
+// getOrGrow returns slot by index, allocating chunk that holds it if needed
+func (t *table[S]) getOrGrow(idx int) *S {
+	if t.covers(idx) {
+		return &t.first[idx*t.stride]
+	}
+	k, off := t.locate(idx)
+	chunk := t.more[k].Load()
+	if chunk == nil {
+		fresh := make([]S, (t.base<<k)*t.stride)
+		if t.more[k].CompareAndSwap(nil, &fresh) {
+			chunk = &fresh
+		} else {
+			chunk = t.more[k].Load()
+		}
+	}
+	return &(*chunk)[off*t.stride]
+}
+
+func (t *table[S]) locate(idx int) (int, int) {
+	if idx < 0 {
+		panic("stags: negative slot index")
+	}
+	j := idx - t.n
+	k := bits.Len(uint(j/t.base+1)) - 1
+	return k, j - t.base*(1<<k-1)
+}
+
+// forEach calls fn for every allocated slot
+func (t *table[S]) forEach(fn func(idx int, slot *S)) {
+	for idx := 0; idx < t.n; idx++ {
+		fn(idx, &t.first[idx*t.stride])
+	}
+	start := t.n
+	for k := range t.more {
+		chunk := t.more[k].Load()
+		if chunk != nil {
+			for off := 0; off < t.base<<k; off++ {
+				fn(start+off, &(*chunk)[off*t.stride])
+			}
+		}
+		start += t.base << k
+	}
+}
@@ -0,0 +1,114 @@
+package stags
+
+import "github.com/go-auxiliaries/tagmap"
+
+// Tx collects reads and writes of a single Update call, it must not be used after fn returns
+type Tx[V any] struct {
+	m      *SafeTagMap[V]
+	reads  map[tagmap.Tag]uint64
+	writes map[tagmap.Tag]txWrite[V]
+}
+
+type txWrite[V any] struct {
+	val     V
+	present bool
+}
+
+// Update runs fn and applies all writes it made at once: Snapshot and other transactions see either all of them or none.
+// Values read via tx are checked on commit, if any of them was changed meanwhile, fn is run again,
+// so fn should have no side effects other than tx writes.
+// If fn returns error, nothing is written and the error is returned as is,
+// unless values fn has read were changed meanwhile, then fn is run again, so it never fails on inconsistent state.
+// !! It will fail if map was created without Consistent option !!
+func (m *SafeTagMap[V]) Update(fn func(tx *Tx[V]) error) error {
+	if m.gate == nil {
+		panic("stags: Update requires Consistent option")
+	}
+	for {
+		tx := &Tx[V]{m: m}
+		err := fn(tx)
+		if err != nil {
+			tx.writes = nil
+		}
+		if tx.commit() {
+			return err
+		}
+	}
+}
+
+// commit validates reads and applies writes, it reports false if any of read tags was changed
+func (tx *Tx[V]) commit() bool {
+	if len(tx.reads) == 0 && len(tx.writes) == 0 {
+		return true
+	}
+	m := tx.m
+	m.gate.lock()
+	defer m.gate.unlock()
+	for tag, read := range tx.reads {
+		if _, _, ver := m.loadVersioned(tag); ver != read {
+			return false
+		}
+	}
+	for tag, write := range tx.writes {
+		m.swap(tag, write.val, write.present)
+	}
+	return true
+}
+
+// Load gets tag value and reports whether it was set, it sees writes made earlier in the same transaction
+func (tx *Tx[V]) Load(tag tagmap.Tag) (V, bool) {
+	if write, ok := tx.writes[tag]; ok {
+		return write.val, write.present
+	}
+	val, ok, ver := tx.m.loadVersioned(tag)
+	// if tag was changed since it was read first, commit fails anyway
+	if _, seen := tx.reads[tag]; !seen {
+		if tx.reads == nil {
+			tx.reads = make(map[tagmap.Tag]uint64)
+		}
+		tx.reads[tag] = ver
+	}
+	return val, ok
+}
+
+func (tx *Tx[V]) GetByTag(tag tagmap.Tag) V {
+	val, _ := tx.Load(tag)
+	return val
+}
+
+// GetByName gets tag value by tag name
+// !! It will fail if tag is unknown !!
+func (tx *Tx[V]) GetByName(name tagmap.TagName) V {
+	return tx.GetByTag(tx.m.getTag(name))
+}
+
+// SetByTag sets tag value, it is written when transaction commits
+// !! It will fail if tag is unknown !!
+func (tx *Tx[V]) SetByTag(tag tagmap.Tag, val V) {
+	tx.m.checkTag(tag)
+	tx.write(tag, val, true)
+}
+
+// SetByName sets tag value by tag name, it is written when transaction commits
+// !! It will fail if tag is unknown !!
+func (tx *Tx[V]) SetByName(name tagmap.TagName, val V) {
+	tx.write(tx.m.getTag(name), val, true)
+}
+
+// DeleteByTag deletes tag value, it is deleted when transaction commits
+func (tx *Tx[V]) DeleteByTag(tag tagmap.Tag) {
+	tx.write(tag, *new(V), false)
+}
+
+// DeleteByName deletes tag value by tag name, it is deleted when transaction commits
+// !! It will fail if tag is unknown !!
+func (tx *Tx[V]) DeleteByName(name tagmap.TagName) {
+	tx.write(tx.m.getTag(name), *new(V), false)
+}
+
+func (tx *Tx[V]) write(tag tagmap.Tag, val V, present bool) {
+	if tx.writes == nil {
+		tx.writes = make(map[tagmap.Tag]txWrite[V])
+	}
+	tx.writes[tag] = txWrite[V]{val: val, present: present}
+}
@@ -0,0 +1,57 @@
+package stags
+
+import (
+	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/pkg/registry"
+)
+
+// registryView resolves tags and names for maps of the package
+type registryView struct {
+	registry *registry.TagRegistry
+	// names are cached from a sealed registry
+	names []tagmap.TagName
+	// fresh is set if registry was sealed before any tag was unregistered, so no tag can ever be stale
+	fresh bool
+}
+
+// newRegistryView caches what a sealed registry allows
+// !! It will fail if requireSealed is true and registry is not sealed !!
+func newRegistryView(r *registry.TagRegistry, requireSealed bool) registryView {
+	v := registryView{registry: r}
+	if r.Frozen() {
+		v.names = r.Names()
+		v.fresh = r.Unregistered() == 0
+	} else if requireSealed {
+		panic(tagmap.ErrNotSealed)
+	}
+	return v
+}
+
+// live reports whether tag was not unregistered, it is cheap until registry unregisters a tag
+func (v *registryView) live(tag tagmap.Tag) bool {
+	return v.fresh || v.registry.Unregistered() == 0 || v.registry.Has(tag)
+}
+
+// name resolves tag name, using names cached from a sealed registry if possible
+func (v *registryView) name(tag tagmap.Tag) tagmap.TagName {
+	if v.names != nil {
+		return v.names[v.registry.IndexOf(tag)]
+	}
+	return v.registry.GetName(tag)
+}
+
+func (v *registryView) getTag(name tagmap.TagName) tagmap.Tag {
+	tag, err := v.registry.LookupTag(name)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+func (v *registryView) IsTagName(name tagmap.TagName) bool {
+	return v.registry.GetTag(name) != tagmap.UnknownTag
+}
+
+func (v *registryView) TagByName(name tagmap.TagName) tagmap.Tag {
+	return v.registry.GetTag(name)
+}
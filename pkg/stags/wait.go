@@ -0,0 +1,49 @@
+package stags
+
+import (
+	"context"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+// WaitByTag blocks until tag value is set and returns it, it returns ctx.Err() if ctx is done first
+// !! It will fail if tag is unknown !!
+func (m *SafeTagMap[V]) WaitByTag(ctx context.Context, tag tagmap.Tag) (V, error) {
+	return m.WaitByTagUntil(ctx, tag, func(_ V, ok bool) bool {
+		return ok
+	})
+}
+
+// WaitByName does the same as WaitByTag, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *SafeTagMap[V]) WaitByName(ctx context.Context, name tagmap.TagName) (V, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
+	}
+	return m.WaitByTag(ctx, tag)
+}
+
+// WaitByTagUntil blocks until cond holds for tag value and returns the value, it returns ctx.Err() if ctx is done first.
+// cond gets the value and whether it is set, it is called on every change of the tag, so it should be cheap.
+// Readers and writers of other tags are not affected by waiting.
+// !! It will fail if tag is unknown !!
+func (m *SafeTagMap[V]) WaitByTagUntil(ctx context.Context, tag tagmap.Tag, cond func(val V, ok bool) bool) (V, error) {
+	if val, ok := m.load(tag); cond(val, ok) {
+		return val, nil
+	}
+	// waiter does not need every change, only a wake-up to check the tag again:
+	// its channel holds a single one regardless of WatchBuffer, changes that find it full are covered by it
+	ch := m.watch(1, tag)
+	defer m.Unwatch(ch)
+	for {
+		// check again, the change could happen before watching started
+		if val, ok := m.load(tag); cond(val, ok) {
+			return val, nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return *new(V), ctx.Err()
+		}
+	}
+}
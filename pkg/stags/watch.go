@@ -0,0 +1,165 @@
+package stags
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-auxiliaries/tagmap"
+)
+
+const defaultWatchBuffer = 64
+
+// Change describes a single write to a watched tag
+type Change[V any] struct {
+	Tag tagmap.Tag
+	Old V
+	// Existed reports whether tag had value before the write, Old is zero value otherwise
+	Existed bool
+	New     V
+	// Deleted reports whether the write deleted tag value, New is zero value then
+	Deleted bool
+	// Version is the version of the tag after the write, see LoadVersioned, gaps in versions reveal missed changes
+	Version uint64
+	// Dropped counts changes that were dropped right before this one because the watcher was not keeping up
+	Dropped uint64
+}
+
+// watcher delivers changes to a buffered channel without ever blocking writers:
+// if the buffer is full, the change is dropped and counted, the count is reported by the next delivered change
+type watcher[V any] struct {
+	tags    map[tagmap.Tag]struct{}
+	ch      chan Change[V]
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+func (w *watcher[V]) accepts(tag tagmap.Tag) bool {
+	if w.tags == nil {
+		return true
+	}
+	_, ok := w.tags[tag]
+	return ok
+}
+
+func (w *watcher[V]) send(c Change[V]) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	c.Dropped = w.dropped.Swap(0)
+	select {
+	case w.ch <- c:
+	default:
+		w.dropped.Add(c.Dropped + 1)
+	}
+}
+
+func (w *watcher[V]) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	close(w.ch)
+}
+
+// watchers is a copy-on-write list, so writes only pay for an atomic load when nobody watches
+type watchers[V any] struct {
+	mu   sync.Mutex
+	list atomic.Pointer[[]*watcher[V]]
+}
+
+// Watch returns channel that receives changes of given tags, or of all tags if none are given.
+// Writers never wait for watchers: when channel buffer (see WatchBuffer) is full, changes are dropped,
+// the next delivered change reports how many were dropped in Change.Dropped.
+// Changes of a tag written concurrently by several goroutines may be delivered out of order, Change.Version tells the order.
+// Call Unwatch to release the channel.
+// !! It will fail if any of tags is unknown !!
+func (m *SafeTagMap[V]) Watch(tags ...tagmap.Tag) <-chan Change[V] {
+	return m.watch(m.watchBuffer, tags...)
+}
+
+// watch does the same as Watch with channel of given buffer size
+func (m *SafeTagMap[V]) watch(buffer int, tags ...tagmap.Tag) <-chan Change[V] {
+	w := &watcher[V]{ch: make(chan Change[V], buffer)}
+	if len(tags) > 0 {
+		w.tags = make(map[tagmap.Tag]struct{}, len(tags))
+		for _, tag := range tags {
+			m.checkTag(tag)
+			w.tags[tag] = struct{}{}
+		}
+	}
+	m.watchers.mu.Lock()
+	defer m.watchers.mu.Unlock()
+	var list []*watcher[V]
+	if prev := m.watchers.list.Load(); prev != nil {
+		list = append(list, *prev...)
+	}
+	list = append(list, w)
+	m.watchers.list.Store(&list)
+	return w.ch
+}
+
+// Unwatch stops delivering changes to channel returned by Watch and closes it
+func (m *SafeTagMap[V]) Unwatch(ch <-chan Change[V]) {
+	m.watchers.mu.Lock()
+	defer m.watchers.mu.Unlock()
+	prev := m.watchers.list.Load()
+	if prev == nil {
+		return
+	}
+	list := make([]*watcher[V], 0, len(*prev))
+	for _, w := range *prev {
+		if w.ch == ch {
+			w.close()
+		} else {
+			list = append(list, w)
+		}
+	}
+	if len(list) == 0 {
+		m.watchers.list.Store(nil)
+	} else {
+		m.watchers.list.Store(&list)
+	}
+}
+
+// OnChange calls fn for every change of given tags, or of all tags if none are given.
+// It is Watch with fn called from a dedicated goroutine, so the same drop policy applies.
+// After stop returns fn is not called again, though a call that is in progress may still be running.
+// !! It will fail if any of tags is unknown !!
+func (m *SafeTagMap[V]) OnChange(fn func(Change[V]), tags ...tagmap.Tag) (stop func()) {
+	ch := m.Watch(tags...)
+	var stopped atomic.Bool
+	go func() {
+		for c := range ch {
+			if !stopped.Load() {
+				fn(c)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopped.Store(true)
+			m.Unwatch(ch)
+		})
+	}
+}
+
+func (m *SafeTagMap[V]) watched() bool {
+	return m.watchers.list.Load() != nil
+}
+
+// notify delivers a write to watchers, writes that did not change anything are skipped
+func (m *SafeTagMap[V]) notify(tag tagmap.Tag, old V, existed bool, new V, present bool, ver uint64) {
+	list := m.watchers.list.Load()
+	if list == nil || (!existed && !present) {
+		return
+	}
+	c := Change[V]{Tag: tag, Old: old, Existed: existed, New: new, Deleted: !present, Version: ver}
+	for _, w := range *list {
+		if w.accepts(tag) {
+			w.send(c)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package tags
+
+type options struct {
+	requireSealed bool
+	defaults      bool
+}
+
+type Option func(*options)
+
+// RequireSealed makes New fail if registry is not sealed
+func RequireSealed() Option {
+	return func(o *options) {
+		o.requireSealed = true
+	}
+}
+
+// Defaults makes New set every tag to its default value, see registry.Meta.Default and registry.DefaultsOf
+// !! New will fail if default of any tag does not fit the map value type !!
+func Defaults() Option {
+	return func(o *options) {
+		o.defaults = true
+	}
+}
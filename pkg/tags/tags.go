@@ -1,24 +1,146 @@
 package tags
 
 import (
-	"reflect"
+	"fmt"
+	"strconv"
 
 	"github.com/go-auxiliaries/tagmap"
 	"github.com/go-auxiliaries/tagmap/pkg/registry"
 )
 
+// TagMap is a map with values indexed by tags, it is not safe for concurrent use.
+// Tags registered after the map was created are supported, the map grows transparently on first write.
+// Presence of values is tracked separately, so tag that was set to zero value is distinguishable from unset one.
+// So are generations of tags that wrote values (see tagmap.Tag.Generation), so value of an unregistered tag
+// is not visible via the tag that reused its index.
 type TagMap[V any] struct {
-	values   []V
+	values  []V
+	present []uint64
+	// gens is nil until a tag of non-zero generation is written
+	gens     []uint32
 	registry *registry.TagRegistry
-	zero     V
+	names    []tagmap.TagName
+	// fresh is set if registry was sealed before any tag was unregistered, so no tag can ever be stale
+	fresh bool
+	zero  V
 }
 
-func New[V any](r *registry.TagRegistry) *TagMap[V] {
-	return &TagMap[V]{
-		values:   make([]V, r.GetLen()),
+var _ tagmap.Map[int] = (*TagMap[int])(nil)
+
+// New creates map for all tags of the registry
+// !! It will fail if RequireSealed is given and registry is not sealed,
+// or if Defaults is given and default of a tag does not fit V !!
+func New[V any](r *registry.TagRegistry, opts ...Option) *TagMap[V] {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &TagMap[V]{
 		registry: r,
 		zero:     *new(V),
 	}
+	if r.Frozen() {
+		m.names = r.Names()
+		m.fresh = r.Unregistered() == 0
+	} else if o.requireSealed {
+		panic(tagmap.ErrNotSealed)
+	}
+	m.values = make([]V, r.GetLen())
+	m.present = make([]uint64, bitmapLen(len(m.values)))
+	if o.defaults {
+		defaults, err := registry.DefaultsOf[V](r)
+		if err != nil {
+			panic(err)
+		}
+		for tag, val := range defaults {
+			m.SetByTag(tag, val)
+		}
+	}
+	return m
+}
+
+func bitmapLen(n int) int {
+	return (n + 63) / 64
+}
+
+// name resolves tag name, using names cached from a sealed registry if possible
+func (m *TagMap[V]) name(tag tagmap.Tag) tagmap.TagName {
+	if m.names != nil {
+		return m.names[m.registry.IndexOf(tag)]
+	}
+	return m.registry.GetName(tag)
+}
+
+// grow makes room for all tags known by the registry
+func (m *TagMap[V]) grow(tag tagmap.Tag, idx int) {
+	n := m.registry.GetLen()
+	if idx < 0 || idx >= n {
+		panic("there is no such tag " + strconv.Itoa(int(tag)))
+	}
+	values := make([]V, n)
+	copy(values, m.values)
+	m.values = values
+	present := make([]uint64, bitmapLen(n))
+	copy(present, m.present)
+	m.present = present
+	if m.gens != nil {
+		gens := make([]uint32, n)
+		copy(gens, m.gens)
+		m.gens = gens
+	}
+}
+
+func (m *TagMap[V]) gen(idx int) uint32 {
+	if m.gens == nil {
+		return 0
+	}
+	return m.gens[idx]
+}
+
+// slot returns index of the tag value and reports whether it belongs to the tag,
+// it does not if nothing was written there yet, if it was written by another generation of the tag
+// or if the tag was unregistered since
+func (m *TagMap[V]) slot(tag tagmap.Tag) (int, bool) {
+	idx := m.registry.IndexOf(tag)
+	return idx, uint(idx) < uint(len(m.values)) && m.gen(idx) == tag.Generation() && m.live(tag)
+}
+
+// live reports whether tag was not unregistered, it is cheap until registry unregisters a tag
+func (m *TagMap[V]) live(tag tagmap.Tag) bool {
+	return m.fresh || m.registry.Unregistered() == 0 || m.registry.Has(tag)
+}
+
+// slotOrGrow returns index of the tag value for writing, value left by an older generation of the tag is dropped
+// !! It will fail if tag is unknown or stale !!
+func (m *TagMap[V]) slotOrGrow(tag tagmap.Tag) int {
+	idx := m.registry.IndexOf(tag)
+	if uint(idx) >= uint(len(m.values)) {
+		m.grow(tag, idx)
+	}
+	if !m.live(tag) {
+		panic(fmt.Errorf("%w: %d", tagmap.ErrStaleTag, tag))
+	}
+	if gen := tag.Generation(); gen != m.gen(idx) {
+		if m.gens == nil {
+			m.gens = make([]uint32, len(m.values))
+		}
+		m.gens[idx] = gen
+		m.values[idx] = m.zero
+		m.markUnset(idx)
+	}
+	return idx
+}
+
+func (m *TagMap[V]) isSet(idx int) bool {
+	return m.present[idx>>6]&(1<<(idx&63)) != 0
+}
+
+func (m *TagMap[V]) markSet(idx int) {
+	m.present[idx>>6] |= 1 << (idx & 63)
+}
+
+func (m *TagMap[V]) markUnset(idx int) {
+	m.present[idx>>6] &^= 1 << (idx & 63)
 }
 
 func (m *TagMap[V]) IsTagName(name tagmap.TagName) bool {
@@ -29,54 +151,91 @@ func (m *TagMap[V]) TagByName(name tagmap.TagName) tagmap.Tag {
 	return m.registry.GetTag(name)
 }
 
+func (m *TagMap[V]) getTag(name tagmap.TagName) tagmap.Tag {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
 // GetByName gets tag value by tag name
 // !! It will fail if tag is unknown !!
-// Make sure you validated tag name via IsTagName
+// Make sure you validated tag name via IsTagName or use LoadByName
 func (m *TagMap[V]) GetByName(name tagmap.TagName) V {
-	return m.GetByTag(m.TagByName(name))
+	return m.GetByTag(m.getTag(name))
+}
+
+// Load gets tag value and reports whether it was set
+func (m *TagMap[V]) Load(tag tagmap.Tag) (V, bool) {
+	idx, ok := m.slot(tag)
+	if !ok || !m.isSet(idx) {
+		return m.zero, false
+	}
+	return m.values[idx], true
+}
+
+// Has reports whether tag value was set
+func (m *TagMap[V]) Has(tag tagmap.Tag) bool {
+	idx, ok := m.slot(tag)
+	return ok && m.isSet(idx)
 }
 
 func (m *TagMap[V]) GetByTag(tag tagmap.Tag) V {
-	return m.values[tag]
+	idx, ok := m.slot(tag)
+	if !ok {
+		return m.zero
+	}
+	return m.values[idx]
 }
 
 // SetByName sets tag value by tag name
 // !! It will fail if tag is unknown !!
-// Make sure you validated tag name via IsTagName
+// Make sure you validated tag name via IsTagName or use StoreByName
 func (m *TagMap[V]) SetByName(name tagmap.TagName, val V) {
-	m.SetByTag(m.TagByName(name), val)
+	m.SetByTag(m.getTag(name), val)
 }
 
 func (m *TagMap[V]) SetByTag(tag tagmap.Tag, val V) {
-	m.values[tag] = val
+	idx := m.slotOrGrow(tag)
+	m.values[idx] = val
+	m.markSet(idx)
 }
 
 // GetByNameOrSet sets tag value by tag name
 // !! It will fail if tag is unknown !!
 // Make sure you validated tag name via IsTagName
 func (m *TagMap[V]) GetByNameOrSet(name tagmap.TagName, val V) (V, bool) {
-	return m.GetByTagOrSet(m.TagByName(name), val)
+	return m.GetByTagOrSet(m.getTag(name), val)
 }
 
+// GetByTagOrSet returns tag value if it is set, otherwise it sets val and returns it,
+// second result reports whether value was loaded, same as sync.Map.LoadOrStore
 func (m *TagMap[V]) GetByTagOrSet(tag tagmap.Tag, val V) (V, bool) {
-	e := m.values[tag]
-	if reflect.ValueOf(val).IsZero() {
-		m.values[tag] = val
-		return val, false
+	idx := m.slotOrGrow(tag)
+	if m.isSet(idx) {
+		return m.values[idx], true
 	}
-	return e, true
+	m.values[idx] = val
+	m.markSet(idx)
+	return val, false
 }
 
 // GetByNameAndDelete sets tag value by tag name
 // !! It will fail if tag is unknown !!
 // Make sure you validated tag name via IsTagName
 func (m *TagMap[V]) GetByNameAndDelete(name tagmap.TagName) V {
-	return m.GetByTagAndDelete(m.TagByName(name))
+	return m.GetByTagAndDelete(m.getTag(name))
 }
 
 func (m *TagMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
-	out := m.values[tag]
-	m.values[tag] = *new(V)
+	idx, ok := m.slot(tag)
+	if !ok {
+		return m.zero
+	}
+	out := m.values[idx]
+	m.values[idx] = m.zero
+	m.markUnset(idx)
 	return out
 }
 
@@ -84,26 +243,106 @@ func (m *TagMap[V]) GetByTagAndDelete(tag tagmap.Tag) V {
 // !! It will fail if tag is unknown !!
 // Make sure you validated tag name via IsTagName
 func (m *TagMap[V]) DeleteByName(name tagmap.TagName) {
-	m.DeleteByTag(m.TagByName(name))
+	m.DeleteByTag(m.getTag(name))
 }
 
 func (m *TagMap[V]) DeleteByTag(tag tagmap.Tag) {
-	m.values[tag] = *new(V)
+	idx, ok := m.slot(tag)
+	if !ok {
+		return
+	}
+	m.values[idx] = m.zero
+	m.markUnset(idx)
+}
+
+// LoadByName gets tag value by tag name, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *TagMap[V]) LoadByName(name tagmap.TagName) (V, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
+	}
+	return m.GetByTag(tag), nil
+}
+
+// StoreByName sets tag value by tag name, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *TagMap[V]) StoreByName(name tagmap.TagName, val V) error {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return err
+	}
+	m.SetByTag(tag, val)
+	return nil
+}
+
+// LoadOrStoreByName does the same as GetByNameOrSet, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *TagMap[V]) LoadOrStoreByName(name tagmap.TagName, val V) (V, bool, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), false, err
+	}
+	actual, loaded := m.GetByTagOrSet(tag, val)
+	return actual, loaded, nil
+}
+
+// LoadAndDeleteByName does the same as GetByNameAndDelete, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *TagMap[V]) LoadAndDeleteByName(name tagmap.TagName) (V, error) {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return *new(V), err
+	}
+	return m.GetByTagAndDelete(tag), nil
+}
+
+// RemoveByName does the same as DeleteByName, it returns tagmap.ErrUnknownTag if tag is unknown
+func (m *TagMap[V]) RemoveByName(name tagmap.TagName) error {
+	tag, err := m.registry.LookupTag(name)
+	if err != nil {
+		return err
+	}
+	m.DeleteByTag(tag)
+	return nil
+}
+
+// forEach calls fn for every tag that has value, values of unregistered tags are skipped
+func (m *TagMap[V]) forEach(fn func(tag tagmap.Tag, val V)) {
+	for idx, value := range m.values {
+		if !m.isSet(idx) {
+			continue
+		}
+		if tag := m.registry.TagAt(idx); tag != tagmap.UnknownTag && tag.Generation() == m.gen(idx) {
+			fn(tag, value)
+		}
+	}
+}
+
+// Remap returns a new map over the target registry of remapping with values of all tags that have translation
+// !! It will fail if map is not built from the source registry of remapping !!
+func (m *TagMap[V]) Remap(rm *registry.Remapping, opts ...Option) *TagMap[V] {
+	if rm.From() != m.registry {
+		panic("tags: Remap requires remapping from the registry of the map")
+	}
+	out := New[V](rm.To(), opts...)
+	m.forEach(func(tag tagmap.Tag, val V) {
+		if tag = rm.Tag(tag); tag != tagmap.UnknownTag {
+			out.SetByTag(tag, val)
+		}
+	})
+	return out
 }
 
 func (m *TagMap[V]) ValuesByTag() map[tagmap.Tag]V {
 	out := make(map[tagmap.Tag]V, len(m.values))
-	for tag, value := range m.values {
-		out[tagmap.Tag(tag)] = value
-	}
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[tag] = val
+	})
 	return out
 }
 
 func (m *TagMap[V]) ValuesByName() map[tagmap.TagName]V {
 	out := make(map[tagmap.TagName]V, len(m.values))
-	for tag, value := range m.values {
-		out[m.registry.GetName(tagmap.Tag(tag))] = value
-	}
+	m.forEach(func(tag tagmap.Tag, val V) {
+		out[m.name(tag)] = val
+	})
 	return out
 }
 
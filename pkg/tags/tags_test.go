@@ -2,8 +2,10 @@ package tags_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/go-auxiliaries/tagmap"
+	"github.com/go-auxiliaries/tagmap/internal/conformance"
 	"github.com/go-auxiliaries/tagmap/pkg/registry"
 	"github.com/go-auxiliaries/tagmap/pkg/tags"
 	"github.com/stretchr/testify/assert"
@@ -64,3 +66,176 @@ func Test(t *testing.T) {
 	assert.Equal(t, "SetByTag2", tagMap.GetByName(tag2Name))
 	assert.Equal(t, "SetByTag3", tagMap.GetByName(tag3Name))
 }
+
+func TestGrow(t *testing.T) {
+	r := registry.New()
+	early := r.RegisterTag("early")
+	m := tags.New[int](r)
+	m.SetByTag(early, 1)
+
+	late := r.RegisterTag("late")
+	assert.Equal(t, 0, m.GetByTag(late))
+	assert.Equal(t, 0, m.GetByTagAndDelete(late))
+	m.DeleteByTag(late)
+
+	m.SetByTag(late, 2)
+	assert.Equal(t, 1, m.GetByTag(early))
+	assert.Equal(t, 2, m.GetByTag(late))
+	assert.Equal(t, 2, m.GetByName("late"))
+	assert.Panics(t, func() { m.SetByTag(tagmap.Tag(2), 0) })
+}
+
+func TestRequireSealed(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	assert.PanicsWithValue(t, tagmap.ErrNotSealed, func() { tags.New[int](r, tags.RequireSealed()) })
+	r.Seal()
+	m := tags.New[int](r, tags.RequireSealed())
+	m.SetByTag(tag, 1)
+	assert.Equal(t, map[tagmap.TagName]int{"tag": 1}, m.ValuesByName())
+}
+
+func TestErrors(t *testing.T) {
+	r := registry.New()
+	tag := r.RegisterTag("tag")
+	m := tags.New[string](r)
+
+	assert.NoError(t, m.StoreByName("tag", "val"))
+	val, err := m.LoadByName("tag")
+	assert.NoError(t, err)
+	assert.Equal(t, "val", val)
+	val, loaded, err := m.LoadOrStoreByName("tag", "other")
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, "val", val)
+	val, err = m.LoadAndDeleteByName("tag")
+	assert.NoError(t, err)
+	assert.Equal(t, "val", val)
+	assert.Equal(t, "", m.GetByTag(tag))
+	assert.NoError(t, m.RemoveByName("tag"))
+
+	_, err = m.LoadByName("unknown")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.StoreByName("unknown", "val"), tagmap.ErrUnknownTag)
+	_, _, err = m.LoadOrStoreByName("unknown", "val")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	_, err = m.LoadAndDeleteByName("unknown")
+	assert.ErrorIs(t, err, tagmap.ErrUnknownTag)
+	assert.ErrorIs(t, m.RemoveByName("unknown"), tagmap.ErrUnknownTag)
+
+	defer func() {
+		assert.ErrorIs(t, recover().(error), tagmap.ErrUnknownTag)
+	}()
+	m.GetByName("unknown")
+}
+
+func TestPresence(t *testing.T) {
+	r := registry.New()
+	tag1 := r.RegisterTag("tag1")
+	tag2 := r.RegisterTag("tag2")
+	m := tags.New[int](r)
+
+	val, ok := m.Load(tag1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, val)
+	assert.False(t, m.Has(tag1))
+	assert.Empty(t, m.ValuesByTag())
+
+	m.SetByTag(tag1, 0)
+	val, ok = m.Load(tag1)
+	assert.True(t, ok)
+	assert.Equal(t, 0, val)
+	assert.True(t, m.Has(tag1))
+	assert.False(t, m.Has(tag2))
+	assert.Equal(t, map[tagmap.Tag]int{tag1: 0}, m.ValuesByTag())
+	assert.Equal(t, map[tagmap.TagName]int{"tag1": 0}, m.ValuesByName())
+
+	val, loaded := m.GetByTagOrSet(tag1, 1)
+	assert.True(t, loaded)
+	assert.Equal(t, 0, val)
+	val, loaded = m.GetByTagOrSet(tag2, 2)
+	assert.False(t, loaded)
+	assert.Equal(t, 2, val)
+
+	m.DeleteByTag(tag1)
+	assert.False(t, m.Has(tag1))
+	assert.Equal(t, 2, m.GetByTagAndDelete(tag2))
+	assert.False(t, m.Has(tag2))
+	assert.Empty(t, m.ValuesByName())
+
+	tag3 := r.RegisterTag("tag3")
+	assert.False(t, m.Has(tag3))
+	m.SetByTag(tag3, 3)
+	val, ok = m.Load(tag3)
+	assert.True(t, ok)
+	assert.Equal(t, 3, val)
+}
+
+func TestGetOrSet(t *testing.T) {
+	conformance.TestGetOrSet(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return tags.New[string](r)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	conformance.TestLoad(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return tags.New[string](r)
+	})
+}
+
+func TestUnregister(t *testing.T) {
+	conformance.TestUnregister(t, func(r *registry.TagRegistry) tagmap.Map[string] {
+		return tags.New[string](r)
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	r := registry.New()
+	other := r.RegisterTag("other")
+	http := r.Namespace("http")
+	get := http.RegisterTag("get")
+	m := tags.New[string](http)
+	post := http.RegisterTag("post")
+
+	m.SetByTag(get, "get")
+	m.SetByName("http.post", "post")
+	assert.Equal(t, "post", m.GetByTag(post))
+	assert.Equal(t, map[tagmap.TagName]string{"http.get": "get", "http.post": "post"}, m.ValuesByName())
+	assert.Equal(t, map[tagmap.Tag]string{get: "get", post: "post"}, m.ValuesByTag())
+	assert.False(t, m.IsTagName("post"))
+	assert.Equal(t, "", m.GetByTag(other))
+	assert.Panics(t, func() { m.SetByTag(other, "") })
+}
+
+func TestRemap(t *testing.T) {
+	from := registry.New()
+	a := from.RegisterTag("a")
+	b := from.RegisterTag("b")
+	m := tags.New[string](from)
+	m.SetByTag(a, "a")
+	m.SetByTag(b, "b")
+	to := registry.New()
+	to.RegisterTag("b")
+
+	rm, err := registry.Remap(from, to, registry.DropMissing)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.TagName]string{"b": "b"}, m.Remap(rm).ValuesByName())
+	rm, err = registry.Remap(from, to, registry.RegisterMissing)
+	assert.NoError(t, err)
+	assert.Equal(t, map[tagmap.Tag]string{0: "b", 1: "a"}, m.Remap(rm).ValuesByTag())
+	assert.Panics(t, func() { tags.New[string](to).Remap(rm) })
+}
+
+func TestDefaults(t *testing.T) {
+	r := registry.New()
+	timeout := r.RegisterTagWithMeta("timeout", registry.Meta{Default: 500})
+	retries := r.RegisterTagWithMeta("retries", registry.Meta{Default: int64(3)})
+	r.RegisterTag("plain")
+
+	assert.Equal(t, map[tagmap.Tag]time.Duration{timeout: 500, retries: 3}, tags.New[time.Duration](r, tags.Defaults()).ValuesByTag())
+	assert.Equal(t, map[tagmap.Tag]int64{timeout: 500, retries: 3}, tags.New[int64](r, tags.Defaults()).ValuesByTag())
+	assert.Empty(t, tags.New[string](r).ValuesByTag())
+	assert.PanicsWithError(t, "default does not fit value type: timeout has int default 500, map holds string", func() {
+		tags.New[string](r, tags.Defaults())
+	})
+}